@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// commandData is what -rm-template and -sync-template are executed against.
+type commandData struct {
+	Bucket   string
+	Filename string
+	FileSize int64
+	Age      string
+	SyncDest string
+
+	// LocalPath is where the file lands under SyncDest.
+	LocalPath string
+
+	// Range is the -range-bytes value, e.g. "0-1023", or empty.
+	Range string
+
+	File FileStruct
+}
+
+// rangeSyncTemplate replaces the sync command when -range-bytes is set,
+// downloading only that part of each object.
+const rangeSyncTemplate = `aws s3api get-object --bucket {{shellquote .Bucket}} --key {{shellquote .Filename}}` +
+	` --range bytes={{.Range}} {{shellword .LocalPath}}`
+
+// parseByteRange validates a -range-bytes value of the form START-END, where
+// both offsets are inclusive as in an HTTP Range header.
+func parseByteRange(value string) (string, error) {
+	startText, endText, ok := strings.Cut(value, "-")
+	if !ok {
+		return "", fmt.Errorf("invalid byte range '%s': use START-END, e.g. '0-1023'", value)
+	}
+	start, err := strconv.ParseUint(startText, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid byte range start '%s': %w", startText, err)
+	}
+	end, err := strconv.ParseUint(endText, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid byte range end '%s': %w", endText, err)
+	}
+	if end < start {
+		return "", fmt.Errorf("invalid byte range '%s': end is before start", value)
+	}
+	return fmt.Sprintf("%d-%d", start, end), nil
+}
+
+// commandTemplates renders the rm and sync command for each file.
+type commandTemplates struct {
+	rm   *template.Template
+	sync *template.Template
+}
+
+// parseCommandTemplates parses the rm and sync templates, falling back to
+// the shell's defaults for empty ones. Templates may call shellquote, which
+// quotes its argument for the shell, and shellword, which only quotes when
+// the argument has special characters.
+func parseCommandTemplates(sh shell, rmText, syncText string) (commandTemplates, error) {
+	if rmText == "" {
+		rmText = sh.rmTemplate
+	}
+	if syncText == "" {
+		syncText = sh.syncTemplate
+	}
+
+	funcs := template.FuncMap{
+		"shellquote": sh.quote,
+		"shellword":  sh.quoteIfNeeded,
+	}
+	rm, err := template.New("rm").Funcs(funcs).Option("missingkey=error").Parse(rmText)
+	if err != nil {
+		return commandTemplates{}, fmt.Errorf("invalid -rm-template: %w", err)
+	}
+	sync, err := template.New("sync").Funcs(funcs).Option("missingkey=error").Parse(syncText)
+	if err != nil {
+		return commandTemplates{}, fmt.Errorf("invalid -sync-template: %w", err)
+	}
+
+	// Catch references to unknown fields now rather than part way through
+	// the output
+	if _, err := render(rm, commandData{}); err != nil {
+		return commandTemplates{}, fmt.Errorf("invalid -rm-template: %w", err)
+	}
+	if _, err := render(sync, commandData{}); err != nil {
+		return commandTemplates{}, fmt.Errorf("invalid -sync-template: %w", err)
+	}
+	return commandTemplates{rm: rm, sync: sync}, nil
+}
+
+func render(tmpl *template.Template, data commandData) (string, error) {
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"0-1023", "0-1023", false},
+		{"100-100", "100-100", false},
+		{"007-010", "7-10", false},
+		{"1024", "", true},
+		{"-1023", "", true},
+		{"0-", "", true},
+		{"10-5", "", true},
+		{"a-b", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteRange(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseByteRange(%q) = %q, %v; want %q, error %t", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseCommandTemplates(t *testing.T) {
+	data := commandData{Bucket: "b", Filename: "it's here.mp4", FileSize: 42, SyncDest: "/tmp/video"}
+
+	tests := []struct {
+		name     string
+		shell    string
+		rm, sync string
+		wantRm   string
+		wantSync string
+		wantErr  string
+	}{
+		{
+			name:     "shell defaults",
+			shell:    "bash",
+			wantRm:   `aws s3 rm 's3://b/it'"'"'s here.mp4'`,
+			wantSync: `aws s3 sync 's3://b' /tmp/video --exclude='*' --include='it'"'"'s here.mp4'`,
+		},
+		{
+			name:     "custom bash",
+			shell:    "bash",
+			rm:       "echo {{.FileSize}} {{shellword .Filename}}",
+			sync:     "cp {{shellquote .Filename}} {{.FileSize}}",
+			wantRm:   `echo 42 'it'"'"'s here.mp4'`,
+			wantSync: `cp 'it'"'"'s here.mp4' 42`,
+		},
+		{
+			name:     "custom powershell",
+			shell:    "powershell",
+			rm:       "Remove-Item {{shellquote .Filename}}",
+			sync:     "Copy-Item {{shellquote .Filename}} {{shellword .SyncDest}}",
+			wantRm:   "Remove-Item 'it''s here.mp4'",
+			wantSync: "Copy-Item 'it''s here.mp4' /tmp/video",
+		},
+		{name: "rm syntax error", shell: "bash", rm: "{{.Filename", wantErr: "invalid -rm-template"},
+		{name: "sync syntax error", shell: "bash", sync: "{{if}}", wantErr: "invalid -sync-template"},
+		{name: "unknown field", shell: "bash", rm: "rm {{.Key}}", wantErr: "invalid -rm-template"},
+		{name: "unknown function", shell: "bash", sync: "{{quote .Filename}}", wantErr: "invalid -sync-template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sh, err := lookupShell(tt.shell)
+			if err != nil {
+				t.Fatal(err)
+			}
+			templates, err := parseCommandTemplates(sh, tt.rm, tt.sync)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rm, err := render(templates.rm, data)
+			if err != nil || rm != tt.wantRm {
+				t.Errorf("rm = %q, %v; want %q", rm, err, tt.wantRm)
+			}
+			sync, err := render(templates.sync, data)
+			if err != nil || sync != tt.wantSync {
+				t.Errorf("sync = %q, %v; want %q", sync, err, tt.wantSync)
+			}
+		})
+	}
+}
+
+func TestRunCustomTemplate(t *testing.T) {
+	listing := "2023-01-02 03:04:05 99 my file.txt\n"
+	dir, _, err := runListing(t, listing, "-rm-template", "gsutil rm {{shellquote .Filename}} # {{.FileSize}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rm := readFile(t, filepath.Join(dir, "rm.sh")); !strings.Contains(rm, "\ngsutil rm 'my file.txt' # 99\n") {
+		t.Errorf("rm.sh does not use the custom template:\n%s", rm)
+	}
+
+	if _, _, err := runListing(t, listing, "-rm-template", "rm {{.Nope}}"); err == nil {
+		t.Error("run accepted a template with an unknown field")
+	}
+}
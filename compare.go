@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+)
+
+// sizeChange is a key present in two listings with different sizes.
+type sizeChange struct {
+	Filename string
+	OldSize  int64
+	NewSize  int64
+}
+
+// sizeChanges returns the keys found in both listings whose FileSize differs,
+// ordered by key. When a listing repeats a key, its last entry wins.
+func sizeChanges(oldFiles, newFiles []FileStruct) []sizeChange {
+	oldSizes := make(map[string]int64, len(oldFiles))
+	for _, file := range oldFiles {
+		oldSizes[file.Filename] = file.FileSize
+	}
+	newSizes := make(map[string]int64, len(newFiles))
+	for _, file := range newFiles {
+		newSizes[file.Filename] = file.FileSize
+	}
+
+	var changes []sizeChange
+	for name, newSize := range newSizes {
+		if oldSize, ok := oldSizes[name]; ok && oldSize != newSize {
+			changes = append(changes, sizeChange{Filename: name, OldSize: oldSize, NewSize: newSize})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Filename < changes[j].Filename })
+	return changes
+}
+
+// runSizeChanged implements -size-changed: it prints the keys whose size
+// differs between the old and new listings. If ctx expires while reading,
+// the partial report is still printed and errTimeout returned.
+func runSizeChanged(ctx context.Context, oldPath, newPath string, opts parseOptions, stdout io.Writer, logger *log.Logger) error {
+	oldFiles, _, err := readListingFile(ctx, oldPath, opts, logger)
+	if err != nil {
+		return err
+	}
+	newFiles, _, err := readListingFile(ctx, newPath, opts, logger)
+	if err != nil {
+		return err
+	}
+
+	changes := sizeChanges(oldFiles, newFiles)
+	for _, change := range changes {
+		fmt.Fprintf(stdout, "%s: %d -> %d bytes (%+d)\n",
+			change.Filename, change.OldSize, change.NewSize, change.NewSize-change.OldSize)
+	}
+	fmt.Fprintf(stdout, "%d keys changed size\n", len(changes))
+	if ctx.Err() != nil {
+		return errTimeout
+	}
+	return nil
+}
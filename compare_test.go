@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSizeChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new []FileStruct
+		want     []sizeChange
+	}{
+		{
+			name: "changed and unchanged",
+			old:  []FileStruct{{Filename: "grew", FileSize: 10}, {Filename: "same", FileSize: 5}},
+			new:  []FileStruct{{Filename: "same", FileSize: 5}, {Filename: "grew", FileSize: 15}},
+			want: []sizeChange{{Filename: "grew", OldSize: 10, NewSize: 15}},
+		},
+		{
+			name: "added and removed keys are not changes",
+			old:  []FileStruct{{Filename: "removed", FileSize: 1}},
+			new:  []FileStruct{{Filename: "added", FileSize: 2}},
+		},
+		{
+			name: "ordered by key",
+			old:  []FileStruct{{Filename: "b", FileSize: 1}, {Filename: "a", FileSize: 1}},
+			new:  []FileStruct{{Filename: "b", FileSize: 0}, {Filename: "a", FileSize: 2}},
+			want: []sizeChange{{Filename: "a", OldSize: 1, NewSize: 2}, {Filename: "b", OldSize: 1, NewSize: 0}},
+		},
+		{
+			name: "last repeated entry wins",
+			old:  []FileStruct{{Filename: "k", FileSize: 1}, {Filename: "k", FileSize: 3}},
+			new:  []FileStruct{{Filename: "k", FileSize: 3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sizeChanges(tt.old, tt.new); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sizeChanges = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunSizeChanged(t *testing.T) {
+	dir := t.TempDir()
+	oldPath, newPath := filepath.Join(dir, "old.txt"), filepath.Join(dir, "new.txt")
+	listings := map[string]string{
+		oldPath: "2023-01-01 00:00:00 100 changed.mp4\n2023-01-01 00:00:00 7 same.mp4\n",
+		newPath: "2023-01-02 00:00:00 250 changed.mp4\n2023-01-02 00:00:00 7 same.mp4\n",
+	}
+	for path, listing := range listings {
+		if err := os.WriteFile(path, []byte(listing), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out, logs bytes.Buffer
+	if err := run([]string{"-size-changed", oldPath, newPath}, &out, &logs); err != nil {
+		t.Fatal(err)
+	}
+	if want := "changed.mp4: 100 -> 250 bytes (+150)\n1 keys changed size\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+
+	if err := run([]string{"-size-changed", oldPath}, &out, &logs); err == nil {
+		t.Error("-size-changed accepted a single listing")
+	}
+
+	out.Reset()
+	err := run([]string{"-timeout", "1ns", "-size-changed", oldPath, newPath}, &out, &logs)
+	if !errors.Is(err, errTimeout) {
+		t.Errorf("error with an expired -timeout = %v, want errTimeout", err)
+	}
+	if !strings.Contains(out.String(), "keys changed size") {
+		t.Errorf("partial report was not printed: %q", out.String())
+	}
+}
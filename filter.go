@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// fileFilter reports whether a file should be kept.
+type fileFilter func(FileStruct) bool
+
+// filterFiles returns the files that pass every filter.
+func filterFiles(files []FileStruct, filters []fileFilter) []FileStruct {
+	if len(filters) == 0 {
+		return files
+	}
+
+	var kept []FileStruct
+	for _, file := range files {
+		if keepFile(file, filters) {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+func keepFile(file FileStruct, filters []fileFilter) bool {
+	for _, filter := range filters {
+		if !filter(file) {
+			return false
+		}
+	}
+	return true
+}
+
+// includeFilter keeps only files whose name matches the regex.
+func includeFilter(regex *regexp.Regexp) fileFilter {
+	return func(file FileStruct) bool {
+		return regex.MatchString(file.Filename)
+	}
+}
+
+// excludeFilter drops files whose name matches the regex.
+func excludeFilter(regex *regexp.Regexp) fileFilter {
+	return func(file FileStruct) bool {
+		return !regex.MatchString(file.Filename)
+	}
+}
+
+// nonEmptyFilter drops zero-byte objects such as directory placeholders and
+// multipart upload leftovers.
+func nonEmptyFilter(file FileStruct) bool {
+	return file.FileSize != 0
+}
+
+// allowFilter keeps only files whose name matches at least one regex.
+func allowFilter(regexes []*regexp.Regexp) fileFilter {
+	return func(file FileStruct) bool {
+		for _, regex := range regexes {
+			if regex.MatchString(file.Filename) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// loadRegexFile compiles one regex per line of path, skipping blank lines
+// and lines starting with #.
+func loadRegexFile(path string) ([]*regexp.Regexp, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var regexes []*regexp.Regexp
+	lineNumber := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		regex, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		regexes = append(regexes, regex)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return regexes, nil
+}
+
+// excludeKeysFilter drops files whose key is in keys.
+func excludeKeysFilter(keys map[string]bool) fileFilter {
+	return func(file FileStruct) bool {
+		return !keys[file.Filename]
+	}
+}
+
+// loadExcludeLog reads previously deleted keys from path. It understands the
+// rm.sh and rm.ps1 scripts this tool generates, the "delete: s3://bucket/key"
+// lines printed by `aws s3 rm`, and plain files with one key per line. Quoted
+// keys are decoded with PowerShell rules when path ends in .ps1 and with bash
+// rules otherwise. Blank lines and comments are ignored.
+func loadExcludeLog(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	unquote := shells["bash"].unquote
+	for _, sh := range shells {
+		if strings.EqualFold(filepath.Ext(path), sh.extension) {
+			unquote = sh.unquote
+		}
+	}
+
+	keys := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys[deletedKey(line, unquote)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// deletedKey extracts the object key from a single exclude-log line, using
+// unquote when the URI is quoted.
+func deletedKey(line string, unquote func(word string) string) string {
+	i := strings.Index(line, "s3://")
+	if i < 0 {
+		return line
+	}
+
+	var uri string
+	if i > 0 && line[i-1] == '\'' {
+		uri = unquote(line[i-1:])
+	} else {
+		uri = line[i:]
+	}
+
+	// Drop the scheme and bucket, leaving only the key
+	uri = strings.TrimPrefix(uri, "s3://")
+	if slash := strings.IndexByte(uri, '/'); slash >= 0 {
+		return uri[slash+1:]
+	}
+	return uri
+}
+
+// unquoteShellWord decodes a leading bash word built from single-quoted
+// segments and the '"'"' idiom used to embed single quotes.
+func unquoteShellWord(word string) string {
+	var out strings.Builder
+	for len(word) > 0 {
+		switch word[0] {
+		case '\'', '"':
+			end := strings.IndexByte(word[1:], word[0])
+			if end < 0 {
+				return out.String() + word[1:]
+			}
+			out.WriteString(word[1 : end+1])
+			word = word[end+2:]
+		case ' ', '\t':
+			return out.String()
+		default:
+			out.WriteByte(word[0])
+			word = word[1:]
+		}
+	}
+	return out.String()
+}
+
+// unquotePowerShellWord decodes a leading PowerShell word built from quoted
+// segments, in which a doubled quote such as ” stands for one literal quote.
+func unquotePowerShellWord(word string) string {
+	var out strings.Builder
+	for len(word) > 0 {
+		switch quote := word[0]; quote {
+		case '\'', '"':
+			word = word[1:]
+			for {
+				end := strings.IndexByte(word, quote)
+				if end < 0 {
+					return out.String() + word
+				}
+				out.WriteString(word[:end])
+				word = word[end+1:]
+				if len(word) == 0 || word[0] != quote {
+					break
+				}
+				out.WriteByte(quote)
+				word = word[1:]
+			}
+		case ' ', '\t':
+			return out.String()
+		default:
+			out.WriteByte(word[0])
+			word = word[1:]
+		}
+	}
+	return out.String()
+}
+
+// markExpiring flags files whose S3 modification time is more than retention
+// ago, matching how lifecycle expiration counts object age.
+func markExpiring(files []FileStruct, retention time.Duration, now time.Time) {
+	for i := range files {
+		files[i].WillExpire = now.Sub(files[i].S3ModificationTime) > retention
+	}
+}
+
+// limitPrefixes keeps the files under the first max top-level prefixes, in
+// the order the prefixes first appear in files, and reports the prefixes it
+// dropped.
+func limitPrefixes(files []FileStruct, max int) ([]FileStruct, []string) {
+	allowed := make(map[string]bool)
+	var skipped []string
+	seen := make(map[string]bool)
+	for _, file := range files {
+		prefix := topLevelPrefix(file.Filename)
+		if seen[prefix] {
+			continue
+		}
+		seen[prefix] = true
+		if len(allowed) < max {
+			allowed[prefix] = true
+		} else {
+			skipped = append(skipped, prefix)
+		}
+	}
+	if len(skipped) == 0 {
+		return files, nil
+	}
+
+	kept := filterFiles(files, []fileFilter{func(file FileStruct) bool {
+		return allowed[topLevelPrefix(file.Filename)]
+	}})
+	return kept, skipped
+}
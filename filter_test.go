@@ -0,0 +1,249 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func filenames(files []FileStruct) []string {
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = file.Filename
+	}
+	return names
+}
+
+func TestFilterFiles(t *testing.T) {
+	files := []FileStruct{
+		{Filename: "a/clip.mp4", FileSize: 10},
+		{Filename: "a/clip.tmp", FileSize: 10},
+		{Filename: "b/empty.mp4", FileSize: 0},
+		{Filename: "c/notes.txt", FileSize: 3},
+	}
+
+	tests := []struct {
+		name    string
+		filters []fileFilter
+		want    []string
+	}{
+		{"no filters", nil, []string{"a/clip.mp4", "a/clip.tmp", "b/empty.mp4", "c/notes.txt"}},
+		{"include", []fileFilter{includeFilter(regexp.MustCompile(`\.mp4$`))}, []string{"a/clip.mp4", "b/empty.mp4"}},
+		{"exclude", []fileFilter{excludeFilter(regexp.MustCompile(`^a/`))}, []string{"b/empty.mp4", "c/notes.txt"}},
+		{"non-empty", []fileFilter{nonEmptyFilter}, []string{"a/clip.mp4", "a/clip.tmp", "c/notes.txt"}},
+		{
+			"exclude wins over include",
+			[]fileFilter{includeFilter(regexp.MustCompile(`\.mp4$`)), excludeFilter(regexp.MustCompile(`^a/`))},
+			[]string{"b/empty.mp4"},
+		},
+		{
+			"allow list",
+			[]fileFilter{allowFilter([]*regexp.Regexp{regexp.MustCompile(`tmp$`), regexp.MustCompile(`^c/`)})},
+			[]string{"a/clip.tmp", "c/notes.txt"},
+		},
+		{
+			"all must pass",
+			[]fileFilter{includeFilter(regexp.MustCompile(`\.mp4$`)), nonEmptyFilter},
+			[]string{"a/clip.mp4"},
+		},
+		{"nothing left", []fileFilter{includeFilter(regexp.MustCompile(`\.mov$`))}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filenames(filterFiles(files, tt.filters)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterFiles = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func writeTempFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "input")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadRegexFile(t *testing.T) {
+	regexes, err := loadRegexFile(writeTempFile(t, "# keep videos\n\\.mp4$\n\n  ^logs/  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, regex := range regexes {
+		got = append(got, regex.String())
+	}
+	if want := []string{`\.mp4$`, `^logs/`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("regexes = %q, want %q", got, want)
+	}
+
+	_, err = loadRegexFile(writeTempFile(t, "ok\n(unclosed\n"))
+	if err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("loadRegexFile error = %v, want one naming line 2", err)
+	}
+}
+
+func TestLoadExcludeLog(t *testing.T) {
+	log := `# S3 Modification Time: 2023-01-02 03:04:05 ...
+aws s3 rm 's3://streamboxdineorb/plain/a.mp4'
+aws s3 rm 's3://streamboxdineorb/it'"'"'s here.mp4'
+delete: s3://streamboxdineorb/from/cli output.mp4
+
+plain/key.txt
+`
+	keys, err := loadExcludeLog(writeTempFile(t, log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"plain/a.mp4": true, "it's here.mp4": true, "from/cli output.mp4": true, "plain/key.txt": true}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("loadExcludeLog = %v, want %v", keys, want)
+	}
+
+	files := []FileStruct{{Filename: "plain/a.mp4"}, {Filename: "kept.mp4"}}
+	if got := filenames(filterFiles(files, []fileFilter{excludeKeysFilter(keys)})); !reflect.DeepEqual(got, []string{"kept.mp4"}) {
+		t.Errorf("excludeKeysFilter kept %q", got)
+	}
+}
+
+func TestLoadExcludeLogPowerShell(t *testing.T) {
+	log := "aws s3 rm 's3://streamboxdineorb/b/it''s.mp4'\r\n" +
+		"aws s3 rm 's3://streamboxdineorb/plain/a.mp4'\r\n"
+	path := filepath.Join(t.TempDir(), "rm.ps1")
+	if err := os.WriteFile(path, []byte(log), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := loadExcludeLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"b/it's.mp4": true, "plain/a.mp4": true}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("loadExcludeLog = %v, want %v", keys, want)
+	}
+}
+
+func TestUnquotePowerShellWord(t *testing.T) {
+	tests := map[string]string{
+		`'plain'`:                   "plain",
+		`'it''s here' trailing`:     "it's here",
+		`bare word`:                 "bare",
+		`'unterminated`:             "unterminated",
+		`'s3://b/a''b''c'`:          "s3://b/a'b'c",
+		`'a''b'`:                    "a'b",
+		`"say ""hi"""`:              `say "hi"`,
+		`'joined'"quoted"unquoted`:  "joinedquotedunquoted",
+		``:                          "",
+		`'spaces inside' "ignored"`: "spaces inside",
+	}
+	for word, want := range tests {
+		if got := unquotePowerShellWord(word); got != want {
+			t.Errorf("unquotePowerShellWord(%q) = %q, want %q", word, got, want)
+		}
+	}
+}
+
+func TestUnquoteShellWord(t *testing.T) {
+	tests := map[string]string{
+		`'plain'`:                   "plain",
+		`'it'"'"'s here' trailing`:  "it's here",
+		`bare word`:                 "bare",
+		`'unterminated`:             "unterminated",
+		`'s3://b/a'"'"'b'"'"'c'`:    "s3://b/a'b'c",
+		`"double" 'single'`:         "double",
+		`'joined'"quoted"unquoted`:  "joinedquotedunquoted",
+		``:                          "",
+		"'with\ttab'":               "with\ttab",
+		`'spaces inside' "ignored"`: "spaces inside",
+	}
+	for word, want := range tests {
+		if got := unquoteShellWord(word); got != want {
+			t.Errorf("unquoteShellWord(%q) = %q, want %q", word, got, want)
+		}
+	}
+}
+
+func TestRunExcludeLog(t *testing.T) {
+	listing := "2023-01-02 03:04:05 1 gone.mp4\n2023-01-02 03:04:05 1 it's gone.mp4\n2023-01-02 03:04:05 1 kept.mp4\n"
+	previous := writeTempFile(t, "aws s3 rm 's3://streamboxdineorb/gone.mp4'\n"+
+		`aws s3 rm 's3://streamboxdineorb/it'"'"'s gone.mp4'`+"\n")
+
+	dir, _, err := runListing(t, listing, "-exclude-log", previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm := readFile(t, filepath.Join(dir, "rm.sh"))
+	if strings.Count(rm, "aws s3 rm ") != 1 || !strings.Contains(rm, "kept.mp4") {
+		t.Errorf("rm.sh should only delete kept.mp4:\n%s", rm)
+	}
+}
+
+func TestRunExcludeLogPowerShell(t *testing.T) {
+	// Feed the rm.ps1 of one run back into the next
+	previous, _, err := runListing(t, "2023-01-02 03:04:05 1 b/it's.mp4\n", "-shell", "powershell")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listing := "2023-01-02 03:04:05 1 b/it's.mp4\n2023-01-02 03:04:05 1 kept.mp4\n"
+	dir, _, err := runListing(t, listing, "-shell", "powershell", "-exclude-log", filepath.Join(previous, "rm.ps1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm := readFile(t, filepath.Join(dir, "rm.ps1"))
+	if strings.Count(rm, "aws s3 rm ") != 1 || !strings.Contains(rm, "kept.mp4") {
+		t.Errorf("rm.ps1 should only delete kept.mp4:\n%s", rm)
+	}
+}
+
+func TestMarkExpiring(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	retention := 30 * 24 * time.Hour
+	files := []FileStruct{
+		{Filename: "old", S3ModificationTime: now.Add(-retention - time.Second)},
+		{Filename: "boundary", S3ModificationTime: now.Add(-retention)},
+		{Filename: "new", S3ModificationTime: now.Add(-time.Hour)},
+	}
+
+	markExpiring(files, retention, now)
+	var got []bool
+	for _, file := range files {
+		got = append(got, file.WillExpire)
+	}
+	if want := []bool{true, false, false}; !reflect.DeepEqual(got, want) {
+		t.Errorf("WillExpire = %v, want %v", got, want)
+	}
+}
+
+func TestLimitPrefixes(t *testing.T) {
+	files := []FileStruct{
+		{Filename: "b/1"},
+		{Filename: "a/1"},
+		{Filename: "top"},
+		{Filename: "b/2"},
+		{Filename: "c/1"},
+		{Filename: "a/2"},
+	}
+
+	tests := []struct {
+		max         int
+		wantFiles   []string
+		wantSkipped []string
+	}{
+		{1, []string{"b/1", "b/2"}, []string{"a/", rootPrefix, "c/"}},
+		{2, []string{"b/1", "a/1", "b/2", "a/2"}, []string{rootPrefix, "c/"}},
+		{4, []string{"b/1", "a/1", "top", "b/2", "c/1", "a/2"}, nil},
+	}
+	for _, tt := range tests {
+		kept, skipped := limitPrefixes(files, tt.max)
+		if got := filenames(kept); !reflect.DeepEqual(got, tt.wantFiles) || !reflect.DeepEqual(skipped, tt.wantSkipped) {
+			t.Errorf("limitPrefixes(%d) = %q, %q; want %q, %q", tt.max, got, skipped, tt.wantFiles, tt.wantSkipped)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/taylormonacelli/ivyprince/listingpb"
+)
+
+// resultFormat is a -format choice for the results file.
+type resultFormat struct {
+	filename string
+	write    func(w io.Writer, doc results) error
+	// embedsSummary is set when the results file carries the summary itself,
+	// so no separate summaryFilename is written.
+	embedsSummary bool
+}
+
+var resultFormats = map[string]resultFormat{
+	"json":          {"results.json", writeResultsJSON, false},
+	"json-document": {"results.json", writeResultsJSONDocument, true},
+	"protobuf":      {"results.pb", writeResultsProtobuf, false},
+	"pbjson-lines":  {"results.jsonl", writeResultsProtoJSONLines, false},
+}
+
+// summaryFilename is written next to results files that only hold the files.
+const summaryFilename = "results.summary.json"
+
+func lookupResultFormat(name string) (resultFormat, error) {
+	format, ok := resultFormats[name]
+	if !ok {
+		return resultFormat{}, fmt.Errorf("invalid format '%s': use 'json', 'json-document', 'protobuf' or 'pbjson-lines'", name)
+	}
+	return format, nil
+}
+
+// writeResultsJSON writes the files as an indented JSON array, the original
+// results.json layout.
+func writeResultsJSON(w io.Writer, doc results) error {
+	return writeIndentedJSON(w, doc.Files)
+}
+
+// writeResultsJSONDocument writes the whole results document, with the
+// summary, input stats and tool version alongside the files, as indented
+// JSON.
+func writeResultsJSONDocument(w io.Writer, doc results) error {
+	return writeIndentedJSON(w, doc)
+}
+
+// resultsSummary is the summaryFilename document.
+type resultsSummary struct {
+	ToolVersion       string             `json:"tool_version"`
+	Summary           summary            `json:"summary"`
+	Input             listingStats       `json:"input"`
+	TimestampCoverage *timestampCoverage `json:"timestamp_coverage,omitempty"`
+}
+
+// writeResultsSummary writes the parts of the results document that are
+// not files, for formats that leave them out.
+func writeResultsSummary(w io.Writer, doc results) error {
+	return writeIndentedJSON(w, resultsSummary{
+		ToolVersion:       doc.ToolVersion,
+		Summary:           doc.Summary,
+		Input:             doc.Input,
+		TimestampCoverage: doc.TimestampCoverage,
+	})
+}
+
+func writeIndentedJSON(w io.Writer, v any) error {
+	// Marshal the sorted files to JSON with indented formatting
+	jsonData, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal to JSON: %w", err)
+	}
+	_, err = w.Write(jsonData)
+	return err
+}
+
+// writeResultsProtobuf writes each file as a varint length-prefixed
+// listingpb.FileRecord, the framing read by protodelim.UnmarshalFrom.
+func writeResultsProtobuf(w io.Writer, doc results) error {
+	for _, file := range doc.Files {
+		if _, err := protodelim.MarshalTo(w, newFileRecord(file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeResultsProtoJSONLines writes each file as a listingpb.FileRecord in
+// the canonical protobuf JSON mapping, one record per line.
+func writeResultsProtoJSONLines(w io.Writer, doc results) error {
+	for _, file := range doc.Files {
+		line, err := protojson.Marshal(newFileRecord(file))
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newFileRecord(file FileStruct) *listingpb.FileRecord {
+	return &listingpb.FileRecord{
+		Key:      file.Filename,
+		Size:     file.FileSize,
+		S3Time:   timestamppb.New(file.S3ModificationTime),
+		FileTime: timestamppb.New(file.FileTimestamp),
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/taylormonacelli/ivyprince/listingpb"
+)
+
+// readResultsDocument decodes a results.json written by -format
+// json-document.
+func readResultsDocument(t *testing.T, path string) results {
+	t.Helper()
+	var doc results
+	if err := json.Unmarshal([]byte(readFile(t, path)), &doc); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return doc
+}
+
+// readResultsSummary decodes the summaryFilename written next to results
+// that only hold the files.
+func readResultsSummary(t *testing.T, path string) resultsSummary {
+	t.Helper()
+	var doc resultsSummary
+	if err := json.Unmarshal([]byte(readFile(t, path)), &doc); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return doc
+}
+
+func testResults() results {
+	s3Time := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	fileTime := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	files := []FileStruct{
+		{S3ModificationTime: s3Time, FileSize: 1234, Filename: "a/20230101_120000.mp4", FileTimestamp: fileTime, TimestampSource: timestampSourceFilename},
+		{S3ModificationTime: s3Time, FileSize: 99, Filename: "it's here.mp4", FileTimestamp: s3Time, TimestampSource: timestampSourceS3},
+	}
+	return results{ToolVersion: "test", Files: files, Summary: summarize(files), Input: listingStats{Lines: 2}}
+}
+
+func TestLookupResultFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		wantErr  bool
+	}{
+		{"json", "results.json", false},
+		{"json-document", "results.json", false},
+		{"protobuf", "results.pb", false},
+		{"pbjson-lines", "results.jsonl", false},
+		{"yaml", "", true},
+	}
+	for _, tt := range tests {
+		format, err := lookupResultFormat(tt.name)
+		if (err != nil) != tt.wantErr || format.filename != tt.filename {
+			t.Errorf("lookupResultFormat(%q) = %q, %v; want %q, error %t", tt.name, format.filename, err, tt.filename, tt.wantErr)
+		}
+	}
+}
+
+func TestWriteResultsJSON(t *testing.T) {
+	doc := testResults()
+
+	var out bytes.Buffer
+	if err := writeResultsJSON(&out, doc); err != nil {
+		t.Fatal(err)
+	}
+	var files []FileStruct
+	if err := json.Unmarshal(out.Bytes(), &files); err != nil {
+		t.Fatalf("-format json is not an array of files: %v\n%s", err, out.String())
+	}
+	if !reflect.DeepEqual(files, doc.Files) {
+		t.Errorf("decoded %+v, want %+v", files, doc.Files)
+	}
+	if !bytes.Contains(out.Bytes(), []byte(`"sort_weight":`)) {
+		t.Errorf("SortWeight is not written as sort_weight:\n%s", out.String())
+	}
+}
+
+func TestWriteResultsJSONDocument(t *testing.T) {
+	doc := testResults()
+
+	var out bytes.Buffer
+	if err := writeResultsJSONDocument(&out, doc); err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(out.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"tool_version", "files", "summary", "input"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("document is missing %q:\n%s", key, out.String())
+		}
+	}
+	if _, ok := fields["timestamp_coverage"]; ok {
+		t.Error("document has timestamp_coverage without -timestamp-coverage")
+	}
+}
+
+func TestWriteResultsProtobuf(t *testing.T) {
+	doc := testResults()
+
+	var out bytes.Buffer
+	if err := writeResultsProtobuf(&out, doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the stream back the way a consumer would
+	reader := bufio.NewReader(&out)
+	var decoded []FileStruct
+	for {
+		record := &listingpb.FileRecord{}
+		err := protodelim.UnmarshalFrom(reader, record)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded = append(decoded, FileStruct{
+			Filename:           record.Key,
+			FileSize:           record.Size,
+			S3ModificationTime: record.S3Time.AsTime(),
+			FileTimestamp:      record.FileTime.AsTime(),
+		})
+	}
+
+	if len(decoded) != len(doc.Files) {
+		t.Fatalf("decoded %d records, want %d", len(decoded), len(doc.Files))
+	}
+	for i, got := range decoded {
+		want := doc.Files[i]
+		if got.Filename != want.Filename || got.FileSize != want.FileSize ||
+			!got.S3ModificationTime.Equal(want.S3ModificationTime) || !got.FileTimestamp.Equal(want.FileTimestamp) {
+			t.Errorf("record %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestWriteResultsProtoJSONLines(t *testing.T) {
+	doc := testResults()
+
+	var out bytes.Buffer
+	if err := writeResultsProtoJSONLines(&out, doc); err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := bufio.NewScanner(&out)
+	var keys []string
+	for scanner.Scan() {
+		record := &listingpb.FileRecord{}
+		if err := protojson.Unmarshal(scanner.Bytes(), record); err != nil {
+			t.Fatalf("%q: %v", scanner.Text(), err)
+		}
+		keys = append(keys, record.Key)
+	}
+	if want := filenames(doc.Files); !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys = %q, want %q", keys, want)
+	}
+}
+
+func TestRunWritesSelectedFormat(t *testing.T) {
+	listing := "2023-01-02 03:04:05 99 a.txt\n"
+	for name, format := range resultFormats {
+		t.Run(name, func(t *testing.T) {
+			dir, _, err := runListing(t, listing, "-format", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(filepath.Join(dir, format.filename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() == 0 {
+				t.Errorf("%s is empty", format.filename)
+			}
+
+			summaryPath := filepath.Join(dir, summaryFilename)
+			if format.embedsSummary {
+				if _, err := os.Stat(summaryPath); !os.IsNotExist(err) {
+					t.Errorf("%s was written alongside a document that embeds the summary", summaryFilename)
+				}
+				return
+			}
+			got := readResultsSummary(t, summaryPath)
+			if got.ToolVersion != version {
+				t.Errorf("tool_version = %q, want %q", got.ToolVersion, version)
+			}
+			if got.Summary.Count != 1 || got.Summary.TotalBytes != 99 || got.Summary.Oldest == nil {
+				t.Errorf("summary = %+v, want 1 file of 99 bytes", got.Summary)
+			}
+		})
+	}
+}
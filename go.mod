@@ -2,4 +2,7 @@ module github.com/taylormonacelli/ivyprince
 
 go 1.20
 
-require github.com/dustin/go-humanize v1.0.1
+require (
+	github.com/dustin/go-humanize v1.0.1
+	google.golang.org/protobuf v1.34.2
+)
@@ -0,0 +1,4 @@
+// Package listingpb holds the protobuf messages written by -format protobuf.
+package listingpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative listing.proto
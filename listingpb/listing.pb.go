@@ -0,0 +1,189 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: listing.proto
+
+package listingpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// FileRecord is one object from a parsed listing.
+type FileRecord struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// key is the object key within the bucket.
+	Key string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	// size is the object size in bytes.
+	Size int64 `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	// s3_time is the S3 modification time.
+	S3Time *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=s3_time,json=s3Time,proto3" json:"s3_time,omitempty"`
+	// file_time is the timestamp embedded in the key, or s3_time when the key
+	// has none.
+	FileTime *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=file_time,json=fileTime,proto3" json:"file_time,omitempty"`
+}
+
+func (x *FileRecord) Reset() {
+	*x = FileRecord{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_listing_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FileRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileRecord) ProtoMessage() {}
+
+func (x *FileRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_listing_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileRecord.ProtoReflect.Descriptor instead.
+func (*FileRecord) Descriptor() ([]byte, []int) {
+	return file_listing_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *FileRecord) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *FileRecord) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *FileRecord) GetS3Time() *timestamppb.Timestamp {
+	if x != nil {
+		return x.S3Time
+	}
+	return nil
+}
+
+func (x *FileRecord) GetFileTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FileTime
+	}
+	return nil
+}
+
+var File_listing_proto protoreflect.FileDescriptor
+
+var file_listing_proto_rawDesc = []byte{
+	0x0a, 0x0d, 0x6c, 0x69, 0x73, 0x74, 0x69, 0x6e, 0x67, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12,
+	0x14, 0x69, 0x76, 0x79, 0x70, 0x72, 0x69, 0x6e, 0x63, 0x65, 0x2e, 0x6c, 0x69, 0x73, 0x74, 0x69,
+	0x6e, 0x67, 0x2e, 0x76, 0x31, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xa0, 0x01, 0x0a, 0x0a, 0x46, 0x69, 0x6c, 0x65, 0x52,
+	0x65, 0x63, 0x6f, 0x72, 0x64, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x12, 0x33, 0x0a, 0x07, 0x73,
+	0x33, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67,
+	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54,
+	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x06, 0x73, 0x33, 0x54, 0x69, 0x6d, 0x65,
+	0x12, 0x37, 0x0a, 0x09, 0x66, 0x69, 0x6c, 0x65, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52,
+	0x08, 0x66, 0x69, 0x6c, 0x65, 0x54, 0x69, 0x6d, 0x65, 0x42, 0x30, 0x5a, 0x2e, 0x67, 0x69, 0x74,
+	0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x74, 0x61, 0x79, 0x6c, 0x6f, 0x72, 0x6d, 0x6f,
+	0x6e, 0x61, 0x63, 0x65, 0x6c, 0x6c, 0x69, 0x2f, 0x69, 0x76, 0x79, 0x70, 0x72, 0x69, 0x6e, 0x63,
+	0x65, 0x2f, 0x6c, 0x69, 0x73, 0x74, 0x69, 0x6e, 0x67, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x33,
+}
+
+var (
+	file_listing_proto_rawDescOnce sync.Once
+	file_listing_proto_rawDescData = file_listing_proto_rawDesc
+)
+
+func file_listing_proto_rawDescGZIP() []byte {
+	file_listing_proto_rawDescOnce.Do(func() {
+		file_listing_proto_rawDescData = protoimpl.X.CompressGZIP(file_listing_proto_rawDescData)
+	})
+	return file_listing_proto_rawDescData
+}
+
+var file_listing_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_listing_proto_goTypes = []any{
+	(*FileRecord)(nil),            // 0: ivyprince.listing.v1.FileRecord
+	(*timestamppb.Timestamp)(nil), // 1: google.protobuf.Timestamp
+}
+var file_listing_proto_depIdxs = []int32{
+	1, // 0: ivyprince.listing.v1.FileRecord.s3_time:type_name -> google.protobuf.Timestamp
+	1, // 1: ivyprince.listing.v1.FileRecord.file_time:type_name -> google.protobuf.Timestamp
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_listing_proto_init() }
+func file_listing_proto_init() {
+	if File_listing_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_listing_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*FileRecord); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_listing_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_listing_proto_goTypes,
+		DependencyIndexes: file_listing_proto_depIdxs,
+		MessageInfos:      file_listing_proto_msgTypes,
+	}.Build()
+	File_listing_proto = out.File
+	file_listing_proto_rawDesc = nil
+	file_listing_proto_goTypes = nil
+	file_listing_proto_depIdxs = nil
+}
@@ -1,233 +1,736 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
-	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/dustin/go-humanize"
 )
 
+// exitTimeout is the exit code used when -timeout expires before the run
+// completes. Output written up to that point is still flushed.
+const exitTimeout = 3
+
+// version, commit and date describe the build. goreleaser sets them with
+// -ldflags "-X main.version=...".
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+// errTimeout is returned by run when -timeout expires.
+var errTimeout = errors.New("timed out")
+
 type FileStruct struct {
 	S3ModificationTime time.Time
 	FileSize           int64
 	Filename           string
 	FileTimestamp      time.Time
+
+	// TimestampSource records where FileTimestamp came from: "filename" when
+	// it was embedded in Filename, "s3" when it fell back to
+	// S3ModificationTime.
+	TimestampSource string
+
+	// WillExpire is set when the object is older than -retention, meaning the
+	// bucket's lifecycle policy is about to remove it anyway.
+	WillExpire bool
+
+	// TimestampFlagged is set when -strict-timestamps kept the file
+	// despite a bad embedded timestamp; FileTimestamp is then its S3 time.
+	TimestampFlagged bool
+
+	// SortWeight is the file's age in days, measured from the newest file in
+	// the listing, divided by its key depth, so old files near the top of the
+	// bucket rank highest. See computeSortWeights.
+	SortWeight float64 `json:"sort_weight"`
 }
 
+const (
+	timestampSourceFilename = "filename"
+	timestampSourceS3       = "s3"
+)
+
+// ByTimestamp and ByS3ModificationTime order files by their respective time,
+// breaking ties by Filename so files sharing a timestamp always come out in
+// the same order. Under sort.Reverse the tiebreak is reversed too.
 type (
 	ByTimestamp          []FileStruct
 	ByS3ModificationTime []FileStruct
 )
 
-func (f ByTimestamp) Len() int           { return len(f) }
-func (f ByTimestamp) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
-func (f ByTimestamp) Less(i, j int) bool { return f[i].FileTimestamp.Before(f[j].FileTimestamp) }
+func (f ByTimestamp) Len() int      { return len(f) }
+func (f ByTimestamp) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f ByTimestamp) Less(i, j int) bool {
+	if !f[i].FileTimestamp.Equal(f[j].FileTimestamp) {
+		return f[i].FileTimestamp.Before(f[j].FileTimestamp)
+	}
+	return f[i].Filename < f[j].Filename
+}
 
 func (f ByS3ModificationTime) Len() int      { return len(f) }
 func (f ByS3ModificationTime) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
 func (f ByS3ModificationTime) Less(i, j int) bool {
-	return f[i].S3ModificationTime.Before(f[j].S3ModificationTime)
+	if !f[i].S3ModificationTime.Equal(f[j].S3ModificationTime) {
+		return f[i].S3ModificationTime.Before(f[j].S3ModificationTime)
+	}
+	return f[i].Filename < f[j].Filename
+}
+
+// BySortWeight orders files by SortWeight, breaking ties by Filename.
+type BySortWeight []FileStruct
+
+func (f BySortWeight) Len() int      { return len(f) }
+func (f BySortWeight) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f BySortWeight) Less(i, j int) bool {
+	if f[i].SortWeight != f[j].SortWeight {
+		return f[i].SortWeight < f[j].SortWeight
+	}
+	return f[i].Filename < f[j].Filename
+}
+
+// computeSortWeights sets SortWeight to the age of FileTimestamp in days
+// divided by the key's depth, its number of slash-separated segments. Ages
+// are measured from the newest FileTimestamp rather than the clock, so the
+// same listing always gets the same weights.
+func computeSortWeights(files []FileStruct) {
+	var newest time.Time
+	for _, file := range files {
+		if file.FileTimestamp.After(newest) {
+			newest = file.FileTimestamp
+		}
+	}
+	for i := range files {
+		depth := strings.Count(files[i].Filename, "/") + 1
+		files[i].SortWeight = newest.Sub(files[i].FileTimestamp).Hours() / 24 / float64(depth)
+	}
+}
+
+// localitySort reorders files so every directory's contents, including its
+// subdirectories, are contiguous. Directories and the files in them are
+// visited in the order they first appear, so the existing sort still decides
+// which directory comes first and the order within each one.
+func localitySort(files []FileStruct) {
+	firstSeen := make(map[string]int)
+	keys := make([][]int, len(files))
+	for i, file := range files {
+		segments := strings.Split(file.Filename, "/")
+		for depth := 1; depth < len(segments); depth++ {
+			dir := strings.Join(segments[:depth], "/")
+			if _, ok := firstSeen[dir]; !ok {
+				firstSeen[dir] = i
+			}
+		}
+	}
+	for i, file := range files {
+		segments := strings.Split(file.Filename, "/")
+		key := make([]int, 0, len(segments))
+		for depth := 1; depth < len(segments); depth++ {
+			key = append(key, firstSeen[strings.Join(segments[:depth], "/")])
+		}
+		keys[i] = append(key, i)
+	}
+
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		ka, kb := keys[order[a]], keys[order[b]]
+		for i := 0; i < len(ka) && i < len(kb); i++ {
+			if ka[i] != kb[i] {
+				return ka[i] < kb[i]
+			}
+		}
+		return len(ka) < len(kb)
+	})
+
+	sorted := make([]FileStruct, len(files))
+	for i, index := range order {
+		sorted[i] = files[index]
+	}
+	copy(files, sorted)
+}
+
+// parseSortOrder reports whether order asks for descending order, rejecting
+// anything it does not recognise rather than defaulting to ascending.
+func parseSortOrder(order string) (bool, error) {
+	switch order {
+	case "asc", "ascending", "a":
+		return false, nil
+	case "desc", "descending", "d":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid sort order '%s': use 'asc' or 'desc'", order)
+	}
+}
+
+// ByKeyHash orders files by a stable FNV-1a hash of Filename, giving an
+// order that looks shuffled but is identical on every run.
+type ByKeyHash []FileStruct
+
+func (f ByKeyHash) Len() int      { return len(f) }
+func (f ByKeyHash) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f ByKeyHash) Less(i, j int) bool {
+	hi, hj := keyHash(f[i].Filename), keyHash(f[j].Filename)
+	if hi != hj {
+		return hi < hj
+	}
+	return f[i].Filename < f[j].Filename
+}
+
+func keyHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// sortKey wraps files in the sort.Interface for the -sort option name, or
+// returns nil for "none", which leaves files in input order.
+func sortKey(name string, files []FileStruct) (sort.Interface, error) {
+	switch name {
+	case "timestamp":
+		return ByTimestamp(files), nil
+	case "s3":
+		return ByS3ModificationTime(files), nil
+	case "hash":
+		return ByKeyHash(files), nil
+	case "weight":
+		return BySortWeight(files), nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("invalid sort option '%s': use 'timestamp', 's3', 'hash', 'weight' or 'none'", name)
+	}
+}
+
+// checkMonotonic verifies that sorted, which wraps files, is in order and
+// reports the first pair of files that is not.
+func checkMonotonic(sorted sort.Interface, files []FileStruct) error {
+	for i := 1; i < sorted.Len(); i++ {
+		if sorted.Less(i, i-1) {
+			return fmt.Errorf("files are out of order at position %d: '%s' (%s) follows '%s' (%s)",
+				i, files[i].Filename, files[i].FileTimestamp.Format(time.RFC3339),
+				files[i-1].Filename, files[i-1].FileTimestamp.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// listFlag collects a repeatable, comma-separated string flag.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
 }
 
 func main() {
-	filename := flag.String("file", "list.txt", "Path to the input file")
-	sortBy := flag.String("sort", "timestamp", "Sort by 'timestamp' or 's3' modification time")
-	sortOrder := flag.String("order", "asc", "Sort order: 'asc' or 'desc'")
-	flag.Parse()
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, errTimeout) {
+			os.Exit(exitTimeout)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("ivyprince", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var inputs listFlag
+	flags.Var(&inputs, "file", "Path to an input listing; repeat or comma-separate to merge several (default list.txt)")
+	delimiter := flags.String("delimiter", "", "Split input lines on this exact separator, e.g. '\\t', instead of whitespace")
+	sizeChanged := flags.Bool("size-changed", false, "Compare two listings given as arguments (old new) and report keys whose size changed")
+	tz := flags.String("tz", "UTC", "IANA time zone, e.g. 'America/New_York', for displayed times and for timestamps embedded in filenames")
+	concurrency := flags.Int("concurrency", 1, "Number of goroutines parsing input lines")
+	maxErrorRate := flags.Float64("max-error-rate", 1, "Exit non-zero after processing if more than this fraction (0-1) of input lines fail to parse")
+	strictTimestamps := flags.Bool("strict-timestamps", false, "Log embedded timestamps that do not parse (e.g. month 13) or are implausible (before 1970, or over a day after the S3 time) and keep the file with its S3 time, instead of dropping unparseable ones and accepting the rest")
+	excludeBadTimestamps := flags.Bool("exclude-bad-timestamps", false, "Drop files whose embedded timestamp does not parse or is implausible, as -strict-timestamps judges them, as failed lines")
+	strict := flags.Bool("strict", false, "Fail on the first input line that cannot be parsed instead of logging and skipping it")
+	maxLineSize := flags.Int("max-line-size", defaultMaxLineSize, "Longest input line accepted, in bytes")
+	sortBy := flags.String("sort", "timestamp", "Sort by 'timestamp', 's3' modification time, 'hash' of the key for a reproducible shuffle, depth-normalized age 'weight', or 'none' to keep input order")
+	sortOrder := flags.String("order", "asc", "Sort order: 'asc' or 'desc' (also 'ascending'/'descending', 'a'/'d')")
+	locality := flags.Bool("locality-sort", false, "After sorting, group keys so each directory's contents are adjacent, for sequential downloads")
+	include := flags.String("include", "", "Only keep files whose name matches this regex")
+	exclude := flags.String("exclude", "", "Drop files whose name matches this regex (wins over -include)")
+	maxPrefixes := flags.Int("max-prefixes", 0, "Only process the first N top-level prefixes, in sorted order, skipping the rest (0 means no limit)")
+	excludeZeroByte := flags.Bool("exclude-zero-byte", false, "Drop zero-byte objects")
+	allowRegexFile := flags.String("allow-regex-file", "", "Only keep files whose name matches any regex in this file (one per line)")
+	excludeLog := flags.String("exclude-log", "", "Skip keys already deleted according to this log or a previous rm.sh or rm.ps1")
+	outputDir := flags.String("output-dir", "", "Directory for the generated scripts and results file (default current directory)")
+	formatName := flags.String("format", "json", "Results file format: 'json' (results.json, an array of files), 'json-document' (results.json with summary, input stats, tool version and -timestamp-coverage), 'protobuf' (length-delimited results.pb) or 'pbjson-lines' (protobuf JSON per line, results.jsonl); all but 'json-document' also write the summary, input stats and tool version to "+summaryFilename)
+	partition := flags.Bool("partition-by-initial", false, "Write outputs into per-directory shards named after the first character of each key")
+	coverage := flags.Bool("timestamp-coverage", false, "Report how many files have a timestamp embedded in their name versus falling back to S3 time, also recorded as timestamp_coverage in the results document or "+summaryFilename)
+	savingsVsAPI := flags.Bool("savings-vs-api", false, "Estimate how many S3 LIST requests reading a saved listing avoided")
+	var patternNames listFlag
+	flags.Var(&patternNames, "timestamp-patterns", "Filename timestamp formats to recognise, tried in order: 'compact' (20060102_150405), 'basic' (20060102T150405), 'dashed' (2006-01-02_15-04-05); repeat or comma-separate (default compact)")
+	detectFormatDrift := flags.Bool("detect-format-drift", false, "Report which known filename timestamp format each file matches and warn when several coexist or files use one not in -timestamp-patterns")
+	showHeatmap := flags.Bool("heatmap", false, "Print a grid counting files by age bucket and size bucket")
+	groupBy := flags.String("group-by", "", "Report file counts and sizes per value of this Go template over each file, e.g. '{{ext .Filename}}'; funcs prefix, dir, base, ext")
+	prefixAgeReport := flags.Bool("prefix-age-report", false, "List each top-level prefix with the age of its oldest file, stalest first")
+	countOnly := flags.Bool("count-only", false, "Only print the count and total size of matching files; write no scripts or results")
+	assertMonotonic := flags.Bool("assert-monotonic", false, "Fail if files are out of -sort order after sorting")
+	ageUnitsName := flags.String("age-units", "days", "Largest unit used for ages: 'days', 'weeks' or 'months' (adds months and years)")
+	shellName := flags.String("shell", "bash", "Script dialect: 'bash' (rm.sh, sync.sh) or 'powershell' (rm.ps1, sync.ps1)")
+	genRm := flags.Bool("gen-rm", true, "Write the rm script")
+	genSync := flags.Bool("gen-sync", true, "Write the sync script")
+	syncDestination := flags.String("sync-dest", defaultSyncDest, "Local directory the sync script downloads into")
+	retention := flags.Duration("retention", 0, "Bucket lifecycle retention, e.g. '720h'; older objects are marked as will-expire (0 disables)")
+	hideExpiring := flags.Bool("hide-expiring", false, "Leave objects marked will-expire out of the rm script")
+	rmBannerSleep := flags.Int("rm-banner-sleep", 5, "Seconds the rm script pauses after announcing what it will delete (0 disables)")
+	rmTemplate := flags.String("rm-template", "", "Go text/template for each rm command; fields .Bucket .Filename .FileSize .Age .SyncDest .LocalPath .Range, funcs shellquote and shellword (default aws s3 rm)")
+	rangeBytes := flags.String("range-bytes", "", "Download only bytes START-END of each file with 'aws s3api get-object --range' instead of syncing it whole")
+	syncTemplate := flags.String("sync-template", "", "Go text/template for each sync command; same fields and funcs as -rm-template (default aws s3 sync)")
+	commandPrefix := flags.String("command-prefix", "", "Prepend this string to every generated command, e.g. 'aws-vault exec prod --'")
+	commentColumns := flags.Bool("comment-columns", false, "Align the time, size, age and key in script comments into columns")
+	otelLogs := flags.String("otel-logs", "", "Write each file as an OTLP/JSON log record, one export request per line, to this path")
+	makeDeps := flags.String("makefile-deps", "", "Write a make dependency (.d) file listing the local paths sync.sh creates")
+	timeout := flags.Duration("timeout", 0, "Maximum runtime, e.g. '30s'; partial output is flushed when exceeded (0 disables)")
+	showVersion := flags.Bool("version", false, "Print the version, commit and build date, then exit")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if *showVersion {
+		fmt.Fprintf(stdout, "version: %s\ncommit: %s\ndate: %s\n", version, commit, date)
+		return nil
+	}
 
-	file, err := os.Open(*filename)
+	desc, err := parseSortOrder(*sortOrder)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	if _, err := sortKey(*sortBy, nil); err != nil {
+		return err
+	}
+	if *assertMonotonic && (*sortBy == "none" || *locality) {
+		return errors.New("-assert-monotonic checks the -sort order, so it cannot be used with -sort none or -locality-sort")
 	}
-	defer file.Close()
 
-	var files []FileStruct
+	units, err := parseAgeUnits(*ageUnitsName)
+	if err != nil {
+		return err
+	}
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		fields := strings.Fields(line)
+	sh, err := lookupShell(*shellName)
+	if err != nil {
+		return err
+	}
 
-		s3Timestamp, err := time.Parse("2006-01-02 15:04:05", fmt.Sprintf("%s %s", fields[0], fields[1]))
+	var groupTemplate *template.Template
+	if *groupBy != "" {
+		groupTemplate, err = parseGroupTemplate(*groupBy)
 		if err != nil {
-			log.Printf("Error parsing S3 modification timestamp for line '%s': %v", line, err)
-			continue
+			return err
 		}
+	}
 
-		fileSize, err := strconv.ParseInt(fields[2], 10, 64)
+	var byteRange string
+	if *rangeBytes != "" {
+		byteRange, err = parseByteRange(*rangeBytes)
 		if err != nil {
-			log.Printf("Error parsing file size for line '%s': %v", line, err)
-			continue
+			return err
 		}
-		filename := strings.Join(fields[3:], " ")
+		if *syncTemplate == "" {
+			*syncTemplate = rangeSyncTemplate
+		}
+	}
 
-		fileTimestamp, err := extractFileTimestamp(filename, s3Timestamp)
+	templates, err := parseCommandTemplates(sh, *rmTemplate, *syncTemplate)
+	if err != nil {
+		return err
+	}
+
+	format, err := lookupResultFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	logger := log.New(stderr, "", log.LstdFlags)
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	var filters []fileFilter
+	if *include != "" {
+		regex, err := regexp.Compile(*include)
+		if err != nil {
+			return fmt.Errorf("invalid -include pattern '%s': %w", *include, err)
+		}
+		filters = append(filters, includeFilter(regex))
+	}
+	if *exclude != "" {
+		regex, err := regexp.Compile(*exclude)
 		if err != nil {
-			log.Printf("Error extracting file timestamp for line '%s': %v", line, err)
-			continue
+			return fmt.Errorf("invalid -exclude pattern '%s': %w", *exclude, err)
 		}
-		files = append(files, FileStruct{
-			S3ModificationTime: s3Timestamp,
-			FileSize:           fileSize,
-			Filename:           filename,
-			FileTimestamp:      fileTimestamp,
-		})
+		filters = append(filters, excludeFilter(regex))
+	}
+	if *excludeZeroByte {
+		filters = append(filters, nonEmptyFilter)
+	}
+	if *allowRegexFile != "" {
+		regexes, err := loadRegexFile(*allowRegexFile)
+		if err != nil {
+			return fmt.Errorf("failed to load allow regex file: %w", err)
+		}
+		filters = append(filters, allowFilter(regexes))
+	}
+	if *excludeLog != "" {
+		keys, err := loadExcludeLog(*excludeLog)
+		if err != nil {
+			return fmt.Errorf("failed to load exclude log: %w", err)
+		}
+		filters = append(filters, excludeKeysFilter(keys))
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+	if *maxErrorRate < 0 || *maxErrorRate > 1 {
+		return fmt.Errorf("invalid -max-error-rate %g: must be between 0 and 1", *maxErrorRate)
+	}
+	if *concurrency < 1 {
+		return fmt.Errorf("invalid -concurrency %d: must be at least 1", *concurrency)
+	}
+	if *maxLineSize < 1 {
+		return fmt.Errorf("invalid -max-line-size %d: must be at least 1", *maxLineSize)
+	}
+	if len(patternNames) == 0 {
+		patternNames = listFlag{defaultTimestampPattern}
+	}
+	patterns, err := lookupTimestampPatterns(patternNames)
+	if err != nil {
+		return err
 	}
+	location, err := time.LoadLocation(*tz)
+	if err != nil {
+		return fmt.Errorf("invalid -tz '%s': %w", *tz, err)
+	}
+	parseOpts := parseOptions{
+		location:    location,
+		maxLineSize: *maxLineSize,
+		concurrency: *concurrency,
+		delimiter:   parseDelimiter(*delimiter),
+		strict:      *strict,
 
-	// Sort the files based on the specified flag
-	switch *sortBy {
-	case "timestamp":
-		if *sortOrder == "desc" {
-			sort.Sort(sort.Reverse(ByTimestamp(files)))
-		} else {
-			sort.Sort(ByTimestamp(files))
+		timestampPatterns: patterns,
+
+		strictTimestamps:     *strictTimestamps,
+		excludeBadTimestamps: *excludeBadTimestamps,
+	}
+
+	if *sizeChanged {
+		if flags.NArg() != 2 {
+			return errors.New("-size-changed needs exactly two listings: old new")
 		}
-	case "s3":
-		if *sortOrder == "desc" {
-			sort.Sort(sort.Reverse(ByS3ModificationTime(files)))
-		} else {
-			sort.Sort(ByS3ModificationTime(files))
+		err := runSizeChanged(ctx, flags.Arg(0), flags.Arg(1), parseOpts, stdout, logger)
+		if errors.Is(err, errTimeout) {
+			return fmt.Errorf("%w after %s; the report is partial", errTimeout, *timeout)
 		}
-	default:
-		log.Fatal("Invalid sort option. Use 'timestamp' or 's3'.")
+		return err
 	}
 
-	filePaths := []string{
-		"rm.sh",
-		"sync.sh",
+	if len(inputs) == 0 {
+		inputs = listFlag{"list.txt"}
+	}
+	files, stats, err := readListingFiles(ctx, inputs, parseOpts, logger)
+	if err != nil {
+		return err
 	}
 
-	for _, filePath := range filePaths {
-		// Check if the file exists
-		if _, err := os.Stat(filePath); err == nil {
-			// File exists, so delete it
-			err := os.Remove(filePath)
-			if err != nil {
-				log.Fatal(err)
-			}
-			log.Println("Deleted file:", filePath)
-		} else if os.IsNotExist(err) {
-			// File does not exist
-			log.Println("File does not exist:", filePath)
-		} else {
-			// Error occurred while checking file existence
-			log.Fatal(err)
+	files = filterFiles(files, filters)
+
+	now := time.Now()
+	if *retention > 0 {
+		markExpiring(files, *retention, now)
+	}
+	computeSortWeights(files)
+
+	// Sort the files based on the specified flag. The sort is stable so
+	// entries identical in every compared field keep their input order.
+	if sorted, _ := sortKey(*sortBy, files); sorted != nil {
+		if desc {
+			sorted = sort.Reverse(sorted)
+		}
+		sort.Stable(sorted)
+	}
+
+	if *locality {
+		localitySort(files)
+	}
+
+	if *maxPrefixes > 0 {
+		var skipped []string
+		files, skipped = limitPrefixes(files, *maxPrefixes)
+		if len(skipped) > 0 {
+			logger.Printf("Warning: -max-prefixes %d skipped %d prefixes: %s",
+				*maxPrefixes, len(skipped), strings.Join(skipped, ", "))
 		}
 	}
 
+	if *assertMonotonic {
+		sorted, _ := sortKey(*sortBy, files)
+		if desc {
+			sorted = sort.Reverse(sorted)
+		}
+		if err := checkMonotonic(sorted, files); err != nil {
+			return err
+		}
+	}
+
+	if *countOnly {
+		fmt.Fprintln(stdout, summarize(files))
+		fmt.Fprintln(stdout, stats)
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w after %s; counts are partial", errTimeout, *timeout)
+		}
+		return checkErrorRate(stats, *maxErrorRate)
+	}
+
 	// Print the sorted files with relative timestamps
-	fmt.Println("Sorted Files:")
+	fmt.Fprintln(stdout, "Sorted Files:")
 	for _, file := range files {
-		relativeTime := formatRelativeTime(file.FileTimestamp)
-		fmt.Printf("S3 Modification Time: %s, %s, %s, age: %s\n",
-			file.S3ModificationTime.Format("2006-01-02 15:04:05"), humanize.Bytes(uint64(file.FileSize)), file.Filename, relativeTime)
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Fprintln(stdout, describeFile(file, units))
+	}
+	fmt.Fprintln(stdout, summarize(files))
+	fmt.Fprintln(stdout, stats)
+	if stats.Prefixes > 0 {
+		fmt.Fprintf(stdout, "Skipped %d PRE prefix lines\n", stats.Prefixes)
+	}
+	if len(stats.Errors) > 0 {
+		fmt.Fprint(stdout, formatErrorHistogram(stats.Errors))
+	}
 
-		// Write the command to stdout with proper quoting in bash
-		comment := fmt.Sprintf("# S3 Modification Time: %s, %s, %s, age: %s\n",
-			file.S3ModificationTime.Format("2006-01-02 15:04:05"), humanize.Bytes(uint64(file.FileSize)), file.Filename, relativeTime)
-		rmCommand := fmt.Sprintf("aws s3 rm 's3://streamboxdineorb/%s'\n", strings.ReplaceAll(file.Filename, "'", "'\"'\"'"))
-		writeToFile("rm.sh", comment+rmCommand)
+	if *coverage {
+		fmt.Fprintln(stdout, computeTimestampCoverage(files))
+	}
+	if *savingsVsAPI {
+		fmt.Fprintf(stdout, "Avoided an estimated %d S3 LIST requests (%d listing entries at %d per request)\n",
+			listRequestsSaved(stats.Lines), stats.Lines, listPageSize)
+	}
+	if *detectFormatDrift {
+		drift := computeFormatDrift(files, patterns)
+		fmt.Fprint(stdout, drift)
+		if drift.mixed() {
+			logger.Printf("Warning: %d filename timestamp formats are in use; naming may have changed", len(drift.counts))
+		}
+		if unconfigured := drift.unconfigured(); len(unconfigured) > 0 {
+			logger.Printf("Warning: some filenames use %s timestamps, which -timestamp-patterns does not include, so they fell back to S3 time",
+				strings.Join(unconfigured, ", "))
+		}
+	}
+	if *showHeatmap {
+		fmt.Fprint(stdout, formatHeatmap(heatmap(files, now)))
+	}
+	if groupTemplate != nil {
+		groups, err := groupFiles(files, groupTemplate)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(stdout, formatGroupReport(groups))
+	}
+	if *prefixAgeReport {
+		fmt.Fprint(stdout, formatPrefixAgeReport(oldestPerPrefix(files), units))
+	}
 
-		// Write the sync command to sync.sh with a comment
-		comment = fmt.Sprintf("# S3 Modification Time: %s, %s, %s, age: %s\n",
-			file.S3ModificationTime.Format("2006-01-02 15:04:05"), humanize.Bytes(uint64(file.FileSize)), file.Filename, relativeTime)
-		syncCommand := fmt.Sprintf("aws s3 sync 's3://streamboxdineorb' /tmp/video --exclude='*' --include='%s'\n", file.Filename)
-		writeToFile("sync.sh", comment+syncCommand)
+	opts := outputOptions{
+		timestampCoverage: *coverage,
+		commentColumns:    *commentColumns,
+		ageUnits:          units,
+		commandPrefix:     *commandPrefix,
+		shell:             sh,
+		templates:         templates,
+		genRm:             *genRm,
+		genSync:           *genSync,
+		syncDest:          *syncDestination,
+		byteRange:         byteRange,
+		hideExpiring:      *hideExpiring,
+		rmBannerSleep:     *rmBannerSleep,
+		format:            format,
+		listingStats:      stats,
 	}
 
-	// Marshal the sorted files to JSON with indented formatting
-	jsonData, err := json.MarshalIndent(files, "", "  ")
-	if err != nil {
-		log.Fatal("Failed to marshal to JSON:", err)
+	// Only the files the scripts were written for go into the remaining
+	// outputs, so a timeout leaves them all describing the same subset
+	var written []FileStruct
+	if *partition {
+		partitions := partitionByInitial(files)
+		initials := make([]string, 0, len(partitions))
+		for initial := range partitions {
+			initials = append(initials, initial)
+		}
+		sort.Strings(initials)
+
+		for _, initial := range initials {
+			dir := filepath.Join(*outputDir, initial)
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return err
+			}
+			partitionFiles, err := writeOutputs(ctx, dir, partitions[initial], opts)
+			if err != nil {
+				return err
+			}
+			written = append(written, partitionFiles...)
+			fmt.Fprintln(stdout, "Results saved to", filepath.Join(dir, opts.format.filename))
+		}
+	} else {
+		if *outputDir != "" {
+			if err := os.MkdirAll(*outputDir, 0o755); err != nil {
+				return err
+			}
+		}
+		written, err = writeOutputs(ctx, *outputDir, files, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, "Results saved to", filepath.Join(*outputDir, opts.format.filename))
 	}
+	files = written
 
-	// Write the JSON data to a file
-	outputFile, err := os.Create("results.json")
-	if err != nil {
-		log.Fatal("Failed to create output file:", err)
+	if *makeDeps != "" {
+		if err := writeStringAtomic(*makeDeps, makefileDeps(files, *syncDestination)); err != nil {
+			return fmt.Errorf("failed to write file '%s': %w", *makeDeps, err)
+		}
+		fmt.Fprintln(stdout, "Dependencies saved to", *makeDeps)
 	}
-	defer outputFile.Close()
 
-	_, err = outputFile.Write(jsonData)
-	if err != nil {
-		log.Fatal("Failed to write JSON data to file:", err)
+	if *otelLogs != "" {
+		if err := writeFileAtomic(*otelLogs, func(w io.Writer) error {
+			return writeOtelLogs(w, files, units)
+		}); err != nil {
+			return fmt.Errorf("failed to write file '%s': %w", *otelLogs, err)
+		}
+		fmt.Fprintln(stdout, "Log records saved to", *otelLogs)
 	}
 
-	fmt.Println("Results saved to results.json")
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w after %s; output is partial", errTimeout, *timeout)
+	}
+	return checkErrorRate(stats, *maxErrorRate)
 }
 
-func extractFileTimestamp(filename string, s3Timestamp time.Time) (time.Time, error) {
-	// Define a regular expression pattern to match the timestamp in the filename
-	pattern := `(\d{8}_\d{6})`
+// checkErrorRate fails when more than maxRate of the input lines failed to
+// parse.
+func checkErrorRate(stats listingStats, maxRate float64) error {
+	if rate := stats.errorRate(); rate > maxRate {
+		return fmt.Errorf("%.1f%% of input lines failed to parse, above -max-error-rate %g", 100*rate, maxRate)
+	}
+	return nil
+}
 
-	// Compile the regular expression
-	regex := regexp.MustCompile(pattern)
+// describeFile renders the one-line summary used for stdout and script comments.
+func describeFile(file FileStruct, units ageUnits) string {
+	description := fmt.Sprintf("S3 Modification Time: %s, %s, %s, age: %s, age from: %s",
+		file.S3ModificationTime.Format("2006-01-02 15:04:05"), humanize.Bytes(uint64(file.FileSize)), file.Filename, formatRelativeTime(file.FileTimestamp, units), file.TimestampSource)
+	if file.TimestampFlagged {
+		description += ", bad file timestamp"
+	}
+	if file.WillExpire {
+		description += ", will expire"
+	}
+	return description
+}
 
-	// Find the timestamp in the filename
-	match := regex.FindStringSubmatch(filename)
-	if match != nil {
-		// Extract the timestamp substring from the match
-		timestampStr := match[0]
+// ageUnits selects the largest unit formatRelativeTime rolls days into.
+type ageUnits int
 
-		// Parse the timestamp
-		fileTimestamp, err := time.Parse("20060102_150405", timestampStr)
-		if err != nil {
-			return s3Timestamp, fmt.Errorf("unable to parse file timestamp: %v", err)
-		}
+const (
+	ageUnitsDays ageUnits = iota
+	ageUnitsWeeks
+	ageUnitsMonths
+)
 
-		return fileTimestamp, nil
+func parseAgeUnits(name string) (ageUnits, error) {
+	switch name {
+	case "days":
+		return ageUnitsDays, nil
+	case "weeks":
+		return ageUnitsWeeks, nil
+	case "months":
+		return ageUnitsMonths, nil
+	default:
+		return 0, fmt.Errorf("invalid age units '%s': use 'days', 'weeks' or 'months'", name)
 	}
-
-	// Return the S3 timestamp if the file timestamp is not found in the filename
-	return s3Timestamp, nil
 }
 
-func formatRelativeTime(timestamp time.Time) string {
+// formatRelativeTime renders the age of timestamp, e.g. "3d 4h 5m". Future
+// timestamps, which show up with clock skew or future-dated exports, render
+// as "in 3h 20m" instead of a negative age.
+func formatRelativeTime(timestamp time.Time, units ageUnits) string {
 	duration := time.Since(timestamp)
+	if duration < 0 {
+		return "in " + formatDuration(-duration, units)
+	}
+	return formatDuration(duration, units)
+}
+
+// formatDuration renders a non-negative duration to whole-second precision.
+// Days roll into weeks, or into approximate 30 day months and 365 day years,
+// depending on units; e.g. "2y 3mo 5d".
+func formatDuration(duration time.Duration, units ageUnits) string {
 	days := int(duration.Hours() / 24)
 	hours := int(duration.Hours()) % 24
 	minutes := int(duration.Minutes()) % 60
 	seconds := int(duration.Seconds()) % 60
 
-	var relativeTime string
-	if days > 0 {
-		relativeTime += fmt.Sprintf("%dd ", days)
-	}
-	if hours > 0 {
-		relativeTime += fmt.Sprintf("%dh ", hours)
+	var years, months, weeks int
+	if units >= ageUnitsMonths {
+		years, days = days/365, days%365
+		months, days = days/30, days%30
 	}
-	if minutes > 0 {
-		relativeTime += fmt.Sprintf("%dm ", minutes)
+	if units >= ageUnitsWeeks {
+		weeks, days = days/7, days%7
 	}
-	if seconds > 0 {
-		relativeTime += fmt.Sprintf("%ds", seconds)
-	}
-
-	return relativeTime
-}
 
-func writeToFile(filename, content string) {
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
-	if err != nil {
-		log.Fatalf("Failed to open file '%s': %v", filename, err)
+	var parts []string
+	for _, part := range []struct {
+		value int
+		unit  string
+	}{
+		{years, "y"},
+		{months, "mo"},
+		{weeks, "w"},
+		{days, "d"},
+		{hours, "h"},
+		{minutes, "m"},
+		{seconds, "s"},
+	} {
+		if part.value > 0 {
+			parts = append(parts, fmt.Sprintf("%d%s", part.value, part.unit))
+		}
 	}
-	defer file.Close()
-
-	_, err = file.WriteString(content)
-	if err != nil {
-		log.Fatalf("Failed to write to file '%s': %v", filename, err)
+	if len(parts) == 0 {
+		return "0s"
 	}
+
+	return strings.Join(parts, " ")
 }
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runListing writes listing to a temp directory and calls run on it with
+// args, sending every output file into that directory.
+func runListing(t *testing.T, listing string, args ...string) (dir, stdout string, err error) {
+	t.Helper()
+	dir = t.TempDir()
+	input := filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(input, []byte(listing), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out, logs bytes.Buffer
+	args = append([]string{"-file", input, "-output-dir", dir}, args...)
+	err = run(args, &out, &logs)
+	return dir, out.String(), err
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestRun(t *testing.T) {
+	listing := "2023-01-02 03:04:05 99 b.txt\n2023-01-01 03:04:05 99 a/20220101_000000.mp4\n"
+	dir, stdout, err := runListing(t, listing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout, "Results saved to "+filepath.Join(dir, "results.json")) {
+		t.Errorf("stdout:\n%s", stdout)
+	}
+	rm := readFile(t, filepath.Join(dir, "rm.sh"))
+	if first, second := strings.Index(rm, "a/20220101_000000.mp4"), strings.Index(rm, "b.txt"); first < 0 || second < first {
+		t.Errorf("rm.sh is not sorted by timestamp:\n%s", rm)
+	}
+}
+
+func TestRunOutputDir(t *testing.T) {
+	listing := "2023-01-02 03:04:05 1 apple.mp4\n"
+	nested := filepath.Join(t.TempDir(), "out", "nested")
+
+	for _, args := range [][]string{{"-output-dir", nested}, {"-output-dir", nested, "-partition-by-initial"}} {
+		if _, _, err := runListing(t, listing, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"rm.sh", "sync.sh", "results.json", "a/rm.sh", "a/results.json"} {
+		if _, err := os.Stat(filepath.Join(nested, name)); err != nil {
+			t.Errorf("-output-dir did not receive %s: %v", name, err)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{[]string{"-include", "("}, "invalid -include pattern"},
+		{[]string{"-exclude", "["}, "invalid -exclude pattern"},
+		{[]string{"-file", "missing.txt"}, "missing.txt"},
+		{[]string{"-no-such-flag"}, "flag provided but not defined"},
+		{[]string{"-shell", "zsh"}, "invalid shell 'zsh'"},
+		{[]string{"-format", "yaml"}, "invalid format 'yaml'"},
+		{[]string{"-range-bytes", "10-5"}, "invalid byte range '10-5'"},
+		{[]string{"-tz", "Mars/Olympus_Mons"}, "invalid -tz 'Mars/Olympus_Mons'"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			_, _, err := runListing(t, "2023-01-02 03:04:05 99 a.txt\n", tt.args...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("run(%q) error = %v, want it to contain %q", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunVersion(t *testing.T) {
+	var out, logs bytes.Buffer
+	if err := run([]string{"-version"}, &out, &logs); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "version: "+version+"\n") {
+		t.Errorf("-version printed %q", out.String())
+	}
+
+	dir, _, err := runListing(t, "2023-01-02 03:04:05 99 a.txt\n", "-format", "json-document")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc := readResultsDocument(t, filepath.Join(dir, "results.json")); doc.ToolVersion != version {
+		t.Errorf("tool_version = %q, want %q", doc.ToolVersion, version)
+	}
+}
+
+func TestRunRejectsInvalidFlagsUpFront(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{[]string{"-order", "sideways"}, "invalid sort order 'sideways'"},
+		{[]string{"-sort", "bogus"}, "'weight' or 'none'"},
+		{[]string{"-max-line-size", "0"}, "invalid -max-line-size 0"},
+		{[]string{"-concurrency", "0"}, "invalid -concurrency 0"},
+		{[]string{"-max-error-rate", "1.5"}, "invalid -max-error-rate 1.5"},
+		{[]string{"-assert-monotonic", "-sort", "none"}, "-assert-monotonic"},
+		{[]string{"-assert-monotonic", "-locality-sort"}, "-assert-monotonic"},
+		{[]string{"-timestamp-patterns", "iso"}, "invalid timestamp pattern 'iso'"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			// The listing does not exist, so any error about it means the
+			// flags were not checked first
+			var out, logs bytes.Buffer
+			err := run(append([]string{"-file", filepath.Join(t.TempDir(), "missing.txt")}, tt.args...), &out, &logs)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("run(%q) error = %v, want it to contain %q", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	listing := "2023-01-02 03:04:05 99 a.txt\n2023-01-02 03:04:05 99 b.txt\n"
+	dir, _, err := runListing(t, listing, "-timeout", "1ns", "-format", "json-document")
+	if !errors.Is(err, errTimeout) {
+		t.Fatalf("error = %v, want errTimeout", err)
+	}
+
+	// Whatever was cut short, the scripts and results must agree
+	doc := readResultsDocument(t, filepath.Join(dir, "results.json"))
+	rm := readFile(t, filepath.Join(dir, "rm.sh"))
+	if got := strings.Count(rm, "aws s3 rm "); got != len(doc.Files) {
+		t.Errorf("rm.sh has %d commands but results.json has %d files", got, len(doc.Files))
+	}
+	if !strings.Contains(rm, "About to delete "+strconv.Itoa(len(doc.Files))+" objects") {
+		t.Errorf("rm.sh banner does not match %d files:\n%s", len(doc.Files), rm)
+	}
+
+	if _, _, err := runListing(t, listing, "-timeout", "1m"); err != nil {
+		t.Errorf("error with a generous timeout = %v", err)
+	}
+}
+
+func TestLocalitySort(t *testing.T) {
+	files := []FileStruct{
+		{Filename: "a/b/1"},
+		{Filename: "c/2"},
+		{Filename: "a/3"},
+		{Filename: "a/b/4"},
+		{Filename: "top"},
+		{Filename: "c/5"},
+	}
+	localitySort(files)
+	want := []string{"a/b/1", "a/b/4", "a/3", "c/2", "c/5", "top"}
+	if got := filenames(files); !reflect.DeepEqual(got, want) {
+		t.Errorf("localitySort = %q, want %q", got, want)
+	}
+}
+
+func TestCheckErrorRate(t *testing.T) {
+	tests := []struct {
+		failed, lines int
+		maxRate       float64
+		wantErr       bool
+	}{
+		{0, 0, 0, false},
+		{1, 10, 0.1, false},
+		{2, 10, 0.1, true},
+		{1, 10, 0, true},
+		{10, 10, 1, false},
+	}
+	for _, tt := range tests {
+		err := checkErrorRate(listingStats{Lines: tt.lines, Failed: tt.failed}, tt.maxRate)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkErrorRate(%d/%d, %g) = %v, want error %t", tt.failed, tt.lines, tt.maxRate, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRunMaxErrorRate(t *testing.T) {
+	listing := "2023-01-02 03:04:05 99 a.txt\nshort line\n"
+	_, stdout, err := runListing(t, listing, "-max-error-rate", "0.4")
+	if err == nil || !strings.Contains(err.Error(), "50.0% of input lines failed to parse") {
+		t.Errorf("error = %v, want the 50%% failure rate reported", err)
+	}
+	if !strings.Contains(stdout, "2 lines read, 1 failed to parse (50.0%)") {
+		t.Errorf("stdout does not report the failed lines:\n%s", stdout)
+	}
+
+	if _, _, err := runListing(t, listing, "-max-error-rate", "0.5"); err != nil {
+		t.Errorf("error at exactly -max-error-rate = %v", err)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		duration time.Duration
+		units    string
+		want     string
+	}{
+		{0, "days", "0s"},
+		{time.Second, "days", "1s"},
+		{90 * time.Second, "days", "1m 30s"},
+		{3*day + 4*time.Hour, "days", "3d 4h"},
+		{26*time.Hour + 5*time.Second, "days", "1d 2h 5s"},
+		{800 * day, "days", "800d"},
+		{17 * day, "weeks", "2w 3d"},
+		{800 * day, "weeks", "114w 2d"},
+		{400 * day, "months", "1y 1mo 5d"},
+		{800*day + time.Hour, "months", "2y 2mo 1w 3d 1h"},
+		{17 * day, "months", "2w 3d"},
+	}
+	for _, tt := range tests {
+		units, err := parseAgeUnits(tt.units)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formatDuration(tt.duration, units); got != tt.want {
+			t.Errorf("formatDuration(%s, %s) = %q, want %q", tt.duration, tt.units, got, tt.want)
+		}
+	}
+
+	if _, err := parseAgeUnits("years"); err == nil {
+		t.Error("parseAgeUnits accepted 'years'")
+	}
+}
+
+func TestFormatRelativeTime(t *testing.T) {
+	if got := formatRelativeTime(time.Now(), ageUnitsDays); got != "0s" {
+		t.Errorf("age of now = %q, want 0s", got)
+	}
+	if got := formatRelativeTime(time.Now().Add(5*time.Minute), ageUnitsDays); got != "in 4m 59s" && got != "in 5m" {
+		t.Errorf("age of 5 minutes from now = %q, want about 'in 5m'", got)
+	}
+	if got := formatRelativeTime(time.Now().Add(-49*time.Hour), ageUnitsDays); got != "2d 1h" {
+		t.Errorf("age of 49 hours ago = %q, want 2d 1h", got)
+	}
+}
+
+func TestRunSortNonePreservesInputOrder(t *testing.T) {
+	listing := "2023-01-03 00:00:00 1 c\n2023-01-01 00:00:00 1 a\n2023-01-02 00:00:00 1 b\n2023-01-04 00:00:00 0 empty\n"
+	dir, _, err := runListing(t, listing, "-sort", "none", "-exclude-zero-byte", "-format", "json-document")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := readResultsDocument(t, filepath.Join(dir, "results.json"))
+	if got, want := filenames(doc.Files), []string{"c", "a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+}
+
+func TestCheckMonotonic(t *testing.T) {
+	early := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	late := early.Add(time.Hour)
+	withTimes := func(times ...time.Time) []FileStruct {
+		var files []FileStruct
+		for i, ts := range times {
+			files = append(files, FileStruct{Filename: string(rune('a' + i)), FileTimestamp: ts, S3ModificationTime: ts})
+		}
+		return files
+	}
+
+	tests := []struct {
+		name    string
+		sortBy  string
+		desc    bool
+		files   []FileStruct
+		wantErr string
+	}{
+		{"ascending", "timestamp", false, withTimes(early, early, late), ""},
+		{"injected out of order", "timestamp", false, withTimes(early, late, early), "out of order at position 2"},
+		{"descending", "timestamp", true, withTimes(late, early), ""},
+		{"descending reverses the tiebreak", "timestamp", true, withTimes(late, late, early), "out of order at position 1"},
+		{"descending out of order", "timestamp", true, withTimes(late, early, late), "out of order at position 2"},
+		{"empty", "timestamp", false, nil, ""},
+		{
+			"s3 order ignores file timestamps", "s3", false,
+			[]FileStruct{
+				{Filename: "a", S3ModificationTime: early, FileTimestamp: late},
+				{Filename: "b", S3ModificationTime: late, FileTimestamp: early},
+			},
+			"",
+		},
+		{
+			"ties broken by filename", "timestamp", false,
+			[]FileStruct{{Filename: "b", FileTimestamp: early}, {Filename: "a", FileTimestamp: early}},
+			"out of order at position 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := sortKey(tt.sortBy, tt.files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.desc {
+				key = sort.Reverse(key)
+			}
+			err = checkMonotonic(key, tt.files)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("checkMonotonic error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseSortOrder(t *testing.T) {
+	tests := []struct {
+		order   string
+		desc    bool
+		wantErr bool
+	}{
+		{"asc", false, false},
+		{"a", false, false},
+		{"ascending", false, false},
+		{"desc", true, false},
+		{"d", true, false},
+		{"descending", true, false},
+		{"", false, true},
+		{"DESC", false, true},
+		{"up", false, true},
+	}
+	for _, tt := range tests {
+		desc, err := parseSortOrder(tt.order)
+		if (err != nil) != tt.wantErr || desc != tt.desc {
+			t.Errorf("parseSortOrder(%q) = %t, %v; want %t, error %t", tt.order, desc, err, tt.desc, tt.wantErr)
+		}
+	}
+}
+
+func TestSortIdenticalTimestampsIsDeterministic(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := []FileStruct{
+		{Filename: "c", FileTimestamp: ts, S3ModificationTime: ts},
+		{Filename: "a", FileTimestamp: ts, S3ModificationTime: ts},
+		{Filename: "d", FileTimestamp: ts.Add(-time.Hour), S3ModificationTime: ts.Add(-time.Hour)},
+		{Filename: "b", FileTimestamp: ts, S3ModificationTime: ts},
+	}
+
+	tests := []struct {
+		name string
+		key  func([]FileStruct) sort.Interface
+		want []string
+	}{
+		{"timestamp", func(f []FileStruct) sort.Interface { return ByTimestamp(f) }, []string{"d", "a", "b", "c"}},
+		{"s3", func(f []FileStruct) sort.Interface { return ByS3ModificationTime(f) }, []string{"d", "a", "b", "c"}},
+		{"timestamp desc", func(f []FileStruct) sort.Interface { return sort.Reverse(ByTimestamp(f)) }, []string{"c", "b", "a", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, input := range [][]FileStruct{files, {files[3], files[2], files[1], files[0]}} {
+				sorted := append([]FileStruct(nil), input...)
+				sort.Stable(tt.key(sorted))
+				if got := filenames(sorted); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("sorted %q to %q, want %q", filenames(input), got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSortByKeyHash(t *testing.T) {
+	var files []FileStruct
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		files = append(files, FileStruct{Filename: name})
+	}
+
+	forward := append([]FileStruct(nil), files...)
+	sort.Stable(ByKeyHash(forward))
+	backward := make([]FileStruct, 0, len(files))
+	for i := len(files) - 1; i >= 0; i-- {
+		backward = append(backward, files[i])
+	}
+	sort.Stable(ByKeyHash(backward))
+
+	if !reflect.DeepEqual(filenames(forward), filenames(backward)) {
+		t.Errorf("hash order depends on input order: %q vs %q", filenames(forward), filenames(backward))
+	}
+	if reflect.DeepEqual(filenames(forward), filenames(files)) {
+		t.Errorf("hash order %q is just the key order", filenames(forward))
+	}
+	for i := 1; i < len(forward); i++ {
+		if keyHash(forward[i-1].Filename) > keyHash(forward[i].Filename) {
+			t.Errorf("%s sorts before %s but has a larger hash", forward[i-1].Filename, forward[i].Filename)
+		}
+	}
+}
+
+func TestRunCountOnly(t *testing.T) {
+	listing := "2023-01-02 03:04:05 10 a.mp4\n2023-01-01 03:04:05 5 b.mp4\n"
+	dir, stdout, err := runListing(t, listing, "-count-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2 files, 15 B total, oldest 2023-01-01 03:04:05, newest 2023-01-02 03:04:05\n" +
+		"2 lines read, 0 failed to parse (0.0%)\n"; stdout != want {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+	for _, name := range []string{"results.json", "rm.sh", "sync.sh"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("-count-only wrote %s", name)
+		}
+	}
+}
+
+func TestComputeSortWeights(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	files := []FileStruct{
+		{Filename: "a/b/c/old.mp4", FileTimestamp: now.Add(-90 * day)},
+		{Filename: "top.mp4", FileTimestamp: now.Add(-10 * day)},
+		{Filename: "a/mid.mp4", FileTimestamp: now.Add(-40 * day)},
+		{Filename: "a/tie.mp4", FileTimestamp: now.Add(-40 * day)},
+		{Filename: "new.mp4", FileTimestamp: now},
+	}
+
+	// Ages count back from new.mp4, the newest file
+	computeSortWeights(files)
+	want := map[string]float64{"a/b/c/old.mp4": 22.5, "top.mp4": 10, "a/mid.mp4": 20, "a/tie.mp4": 20, "new.mp4": 0}
+	for _, file := range files {
+		if file.SortWeight != want[file.Filename] {
+			t.Errorf("%s weight = %v, want %v", file.Filename, file.SortWeight, want[file.Filename])
+		}
+	}
+
+	// The same listing seen a year later gets the same weights
+	later := make([]FileStruct, len(files))
+	for i, file := range files {
+		file.FileTimestamp = file.FileTimestamp.Add(365 * day)
+		later[i] = file
+	}
+	computeSortWeights(later)
+	for _, file := range later {
+		if file.SortWeight != want[file.Filename] {
+			t.Errorf("a year later, %s weight = %v, want %v", file.Filename, file.SortWeight, want[file.Filename])
+		}
+	}
+
+	sort.Stable(BySortWeight(files))
+	if got, want := filenames(files), []string{"new.mp4", "top.mp4", "a/mid.mp4", "a/tie.mp4", "a/b/c/old.mp4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("BySortWeight order = %q, want %q", got, want)
+	}
+	sort.Stable(sort.Reverse(BySortWeight(files)))
+	if got, want := filenames(files), []string{"a/b/c/old.mp4", "a/tie.mp4", "a/mid.mp4", "top.mp4", "new.mp4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("reversed BySortWeight order = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// otelLogsData is the OTLP/JSON export envelope, the layout collectors such
+// as the otlpjsonfile receiver read one of per line.
+type otelLogsData struct {
+	ResourceLogs []otelResourceLogs `json:"resourceLogs"`
+}
+
+type otelResourceLogs struct {
+	Resource  otelResource    `json:"resource"`
+	ScopeLogs []otelScopeLogs `json:"scopeLogs"`
+}
+
+type otelResource struct {
+	Attributes []otelAttribute `json:"attributes"`
+}
+
+type otelScopeLogs struct {
+	Scope      otelScope       `json:"scope"`
+	LogRecords []otelLogRecord `json:"logRecords"`
+}
+
+type otelScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// otelLogRecord is an OpenTelemetry log record in the OTLP/JSON encoding,
+// where 64-bit integers are carried as strings.
+type otelLogRecord struct {
+	TimeUnixNano         string          `json:"timeUnixNano"`
+	ObservedTimeUnixNano string          `json:"observedTimeUnixNano"`
+	SeverityNumber       int             `json:"severityNumber"`
+	SeverityText         string          `json:"severityText"`
+	Body                 otelAnyValue    `json:"body"`
+	Attributes           []otelAttribute `json:"attributes"`
+}
+
+type otelAttribute struct {
+	Key   string       `json:"key"`
+	Value otelAnyValue `json:"value"`
+}
+
+type otelAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func otelString(s string) otelAnyValue { return otelAnyValue{StringValue: &s} }
+
+func otelInt(i int64) otelAnyValue {
+	s := strconv.FormatInt(i, 10)
+	return otelAnyValue{IntValue: &s}
+}
+
+// otelSeverityInfo is the OTel severity number for INFO.
+const otelSeverityInfo = 9
+
+// newOtelLogRecord describes file as a log record timestamped with its
+// FileTimestamp and observed at now.
+func newOtelLogRecord(file FileStruct, now time.Time, units ageUnits) otelLogRecord {
+	return otelLogRecord{
+		TimeUnixNano:         strconv.FormatInt(file.FileTimestamp.UnixNano(), 10),
+		ObservedTimeUnixNano: strconv.FormatInt(now.UnixNano(), 10),
+		SeverityNumber:       otelSeverityInfo,
+		SeverityText:         "INFO",
+		Body:                 otelString(file.Filename),
+		Attributes: []otelAttribute{
+			{"s3.key", otelString(file.Filename)},
+			{"s3.size", otelInt(file.FileSize)},
+			{"s3.modification_time", otelString(file.S3ModificationTime.Format(time.RFC3339))},
+			{"file.timestamp_source", otelString(file.TimestampSource)},
+			{"file.age", otelString(formatRelativeTime(file.FileTimestamp, units))},
+			{"file.age_seconds", otelInt(int64(now.Sub(file.FileTimestamp).Seconds()))},
+		},
+	}
+}
+
+// newOtelLogsData wraps records in an export envelope whose resource is the
+// bucket they describe.
+func newOtelLogsData(records []otelLogRecord) otelLogsData {
+	return otelLogsData{ResourceLogs: []otelResourceLogs{{
+		Resource: otelResource{Attributes: []otelAttribute{
+			{"service.name", otelString("ivyprince")},
+			{"s3.bucket", otelString(bucket)},
+		}},
+		ScopeLogs: []otelScopeLogs{{
+			Scope:      otelScope{Name: "ivyprince", Version: version},
+			LogRecords: records,
+		}},
+	}}}
+}
+
+// writeOtelLogs writes one OTLP/JSON export envelope per line, each holding
+// the log record for one file.
+func writeOtelLogs(w io.Writer, files []FileStruct, units ageUnits) error {
+	now := time.Now()
+	encoder := json.NewEncoder(w)
+	for _, file := range files {
+		if err := encoder.Encode(newOtelLogsData([]otelLogRecord{newOtelLogRecord(file, now, units)})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWriteOtelLogs(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := []FileStruct{
+		{Filename: "a.mp4", FileSize: 1234, S3ModificationTime: ts, FileTimestamp: ts, TimestampSource: timestampSourceS3},
+		{Filename: "b.mp4", FileSize: 1, S3ModificationTime: ts, FileTimestamp: ts, TimestampSource: timestampSourceS3},
+	}
+
+	var out bytes.Buffer
+	if err := writeOtelLogs(&out, files, ageUnitsDays); err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := bufio.NewScanner(&out)
+	var bodies []string
+	for scanner.Scan() {
+		var envelope otelLogsData
+		if err := json.Unmarshal(scanner.Bytes(), &envelope); err != nil {
+			t.Fatalf("%s: %v", scanner.Text(), err)
+		}
+		if len(envelope.ResourceLogs) != 1 || len(envelope.ResourceLogs[0].ScopeLogs) != 1 {
+			t.Fatalf("envelope is not one resource with one scope: %s", scanner.Text())
+		}
+		resourceLogs := envelope.ResourceLogs[0]
+
+		resource := make(map[string]string)
+		for _, attribute := range resourceLogs.Resource.Attributes {
+			resource[attribute.Key] = *attribute.Value.StringValue
+		}
+		if resource["service.name"] != "ivyprince" || resource["s3.bucket"] != bucket {
+			t.Errorf("resource attributes = %v", resource)
+		}
+
+		records := resourceLogs.ScopeLogs[0].LogRecords
+		if len(records) != 1 {
+			t.Fatalf("got %d records in one envelope, want 1", len(records))
+		}
+		attributes := make(map[string]otelAnyValue)
+		for _, attribute := range records[0].Attributes {
+			attributes[attribute.Key] = attribute.Value
+		}
+		if _, ok := attributes["s3.bucket"]; ok {
+			t.Error("s3.bucket is repeated on the record")
+		}
+		if v := attributes["s3.size"].IntValue; v == nil || (*v != "1234" && *v != "1") {
+			t.Errorf("s3.size attribute = %v, want a string-encoded int", v)
+		}
+		if records[0].TimeUnixNano != "1672628645000000000" {
+			t.Errorf("timeUnixNano = %s", records[0].TimeUnixNano)
+		}
+		bodies = append(bodies, *records[0].Body.StringValue)
+	}
+	if len(bodies) != 2 || bodies[0] != "a.mp4" || bodies[1] != "b.mp4" {
+		t.Errorf("record bodies = %q, want one line per file", bodies)
+	}
+}
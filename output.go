@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dustin/go-humanize"
+)
+
+// defaultSyncDest is the local directory sync.sh downloads into by default.
+const defaultSyncDest = "/tmp/video"
+
+// outputOptions controls what writeOutputs generates.
+type outputOptions struct {
+	timestampCoverage bool
+	commentColumns    bool
+	ageUnits          ageUnits
+
+	// commandPrefix is prepended verbatim to every generated command, e.g.
+	// "aws-vault exec prod --".
+	commandPrefix string
+
+	// shell selects the script dialect and file extension.
+	shell shell
+
+	// templates render the rm and sync commands.
+	templates commandTemplates
+
+	// genRm and genSync select which scripts are written.
+	genRm   bool
+	genSync bool
+
+	// syncDest is the local directory the sync script downloads into.
+	syncDest string
+
+	// byteRange, when set, is the START-END part of each file to download.
+	byteRange string
+
+	// hideExpiring leaves files a lifecycle rule will expire out of the rm
+	// script.
+	hideExpiring bool
+
+	// rmBannerSleep is how long the rm script pauses after announcing what
+	// it will delete.
+	rmBannerSleep int
+
+	// format selects the encoding and name of the results file.
+	format resultFormat
+
+	// listingStats describes the input and goes into the results document.
+	listingStats listingStats
+}
+
+// results is everything the results file can hold; each format decides how
+// much of it to write. -format json-document writes all of it.
+type results struct {
+	ToolVersion       string             `json:"tool_version"`
+	Files             []FileStruct       `json:"files"`
+	Summary           summary            `json:"summary"`
+	Input             listingStats       `json:"input"`
+	TimestampCoverage *timestampCoverage `json:"timestamp_coverage,omitempty"`
+}
+
+// writeOutputs generates the rm and sync scripts and the results file for
+// files inside dir. An empty dir means the current directory. If ctx is done
+// part way through, every output covers only the files rendered so far; that
+// subset is returned.
+func writeOutputs(ctx context.Context, dir string, files []FileStruct, opts outputOptions) ([]FileStruct, error) {
+	// Unlike aws s3 sync, get-object does not create the directories it
+	// writes into, so range downloads are preceded by a mkdir the first time
+	// each directory comes up
+	createdDirs := make(map[string]bool)
+	var rmCommands, syncCommands, mkdirCommands []string
+	for _, file := range files {
+		if ctx.Err() != nil {
+			break
+		}
+
+		data := commandData{
+			Bucket:   bucket,
+			Filename: file.Filename,
+			FileSize: file.FileSize,
+			Age:      formatRelativeTime(file.FileTimestamp, opts.ageUnits),
+			SyncDest: opts.syncDest,
+
+			LocalPath: path.Join(opts.syncDest, file.Filename),
+			Range:     opts.byteRange,
+
+			File: file,
+		}
+		rmCommand, err := render(opts.templates.rm, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render rm command for '%s': %w", file.Filename, err)
+		}
+		syncCommand, err := render(opts.templates.sync, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render sync command for '%s': %w", file.Filename, err)
+		}
+		var mkdirCommand string
+		if dir := path.Dir(data.LocalPath); opts.byteRange != "" && !createdDirs[dir] {
+			createdDirs[dir] = true
+			mkdirCommand = opts.shell.mkdir(dir) + "\n"
+		}
+		rmCommands = append(rmCommands, rmCommand)
+		syncCommands = append(syncCommands, syncCommand)
+		mkdirCommands = append(mkdirCommands, mkdirCommand)
+	}
+	files = files[:len(rmCommands)]
+
+	var rmScript, syncScript strings.Builder
+	header, comments := scriptComments(files, opts.commentColumns, opts.ageUnits)
+	rmFiles := files
+	if opts.hideExpiring {
+		rmFiles = filterFiles(files, []fileFilter{func(file FileStruct) bool { return !file.WillExpire }})
+	}
+	rmScript.WriteString(opts.shell.rmBanner(rmFiles, opts.rmBannerSleep))
+	rmScript.WriteString(header)
+	syncScript.WriteString(header)
+	for i, file := range files {
+		comment := comments[i] + "\n"
+		if !opts.hideExpiring || !file.WillExpire {
+			rmScript.WriteString(comment + withPrefix(opts.commandPrefix, rmCommands[i]) + "\n")
+		}
+		syncScript.WriteString(comment + mkdirCommands[i] + withPrefix(opts.commandPrefix, syncCommands[i]) + "\n")
+	}
+
+	type script struct{ name, content string }
+	var scripts []script
+	if opts.genRm {
+		scripts = append(scripts, script{"rm" + opts.shell.extension, rmScript.String()})
+	}
+	if opts.genSync {
+		scripts = append(scripts, script{"sync" + opts.shell.extension, syncScript.String()})
+	}
+	for _, script := range scripts {
+		path := filepath.Join(dir, script.name)
+		if err := writeStringAtomic(path, opts.shell.script(script.content)); err != nil {
+			return nil, fmt.Errorf("failed to write file '%s': %w", path, err)
+		}
+	}
+
+	doc := results{ToolVersion: version, Files: files, Summary: summarize(files), Input: opts.listingStats}
+	if opts.timestampCoverage {
+		coverage := computeTimestampCoverage(files)
+		doc.TimestampCoverage = &coverage
+	}
+
+	resultsPath := filepath.Join(dir, opts.format.filename)
+	if err := writeFileAtomic(resultsPath, func(w io.Writer) error {
+		return opts.format.write(w, doc)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to write results to '%s': %w", resultsPath, err)
+	}
+	if !opts.format.embedsSummary {
+		summaryPath := filepath.Join(dir, summaryFilename)
+		if err := writeFileAtomic(summaryPath, func(w io.Writer) error {
+			return writeResultsSummary(w, doc)
+		}); err != nil {
+			return nil, fmt.Errorf("failed to write summary to '%s': %w", summaryPath, err)
+		}
+	}
+	return files, nil
+}
+
+func withPrefix(prefix, command string) string {
+	if prefix == "" {
+		return command
+	}
+	return prefix + " " + command
+}
+
+// scriptComments returns the comment placed above each file's command, plus
+// an optional header for the top of the script. With columns set, the fields
+// are padded into aligned columns across all files and the header names them.
+func scriptComments(files []FileStruct, columns bool, units ageUnits) (string, []string) {
+	comments := make([]string, len(files))
+	if !columns {
+		for i, file := range files {
+			comments[i] = "# " + describeFile(file, units)
+		}
+		return "", comments
+	}
+
+	var table strings.Builder
+	tw := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "# S3 MODIFICATION TIME\tSIZE\tAGE\tAGE FROM\tKEY")
+	for _, file := range files {
+		fmt.Fprintf(tw, "# %s\t%s\t%s\t%s\t%s\n",
+			file.S3ModificationTime.Format("2006-01-02 15:04:05"), humanize.Bytes(uint64(file.FileSize)),
+			formatRelativeTime(file.FileTimestamp, units), file.TimestampSource, file.Filename)
+	}
+	tw.Flush()
+
+	lines := strings.Split(strings.TrimSuffix(table.String(), "\n"), "\n")
+	copy(comments, lines[1:])
+	return lines[0] + "\n", comments
+}
+
+// makefileDeps renders a make dependency file that declares every path
+// sync.sh downloads as a target with no prerequisites, so make treats the
+// files as up to date once they exist locally.
+func makefileDeps(files []FileStruct, syncDest string) string {
+	var deps strings.Builder
+	deps.WriteString("# Generated by ivyprince; local paths written by sync.sh\n")
+	for _, file := range files {
+		deps.WriteString(makeEscaper.Replace(path.Join(syncDest, file.Filename)) + ":\n")
+	}
+	return deps.String()
+}
+
+// makeEscaper escapes the characters make treats specially in target names.
+var makeEscaper = strings.NewReplacer(
+	"$", "$$",
+	"#", "\\#",
+	" ", "\\ ",
+	":", "\\:",
+	"%", "\\%",
+)
+
+// partitionByInitial groups files by the lowercased first character of their
+// key. Keys starting with anything other than a letter or digit share the "_"
+// partition so the result is always a safe directory name.
+func partitionByInitial(files []FileStruct) map[string][]FileStruct {
+	partitions := make(map[string][]FileStruct)
+	for _, file := range files {
+		initial := "_"
+		r, _ := utf8.DecodeRuneInString(file.Filename)
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			initial = string(unicode.ToLower(r))
+		}
+		partitions[initial] = append(partitions[initial], file)
+	}
+	return partitions
+}
+
+// writeFileAtomic streams content into a temp file in the same directory as
+// path and renames it into place only after the write has been flushed and
+// synced. Readers therefore see either the previous file or the complete new
+// one, never a partial write.
+func writeFileAtomic(path string, write func(w io.Writer) error) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	if err = write(w); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(0o644); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeStringAtomic is writeFileAtomic for content that is already in memory.
+func writeStringAtomic(path, content string) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, content)
+		return err
+	})
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.txt")
+	if err := writeStringAtomic(path, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "first" {
+		t.Fatalf("file holds %q, want first", got)
+	}
+
+	failure := errors.New("write failed")
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("error = %v, want %v", err, failure)
+	}
+	if got := readFile(t, path); got != "first" {
+		t.Errorf("after a failed write the file holds %q, want the previous content", got)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temp files were left behind: %v", entries)
+	}
+}
+
+func TestPartitionByInitial(t *testing.T) {
+	files := []FileStruct{{Filename: "Apple"}, {Filename: "avocado"}, {Filename: "9lives"}, {Filename: ".hidden"}, {Filename: "été"}}
+	want := map[string][]string{
+		"a": {"Apple", "avocado"},
+		"9": {"9lives"},
+		"_": {".hidden"},
+		"é": {"été"},
+	}
+
+	got := make(map[string][]string)
+	for initial, partition := range partitionByInitial(files) {
+		got[initial] = filenames(partition)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("partitionByInitial = %q, want %q", got, want)
+	}
+}
+
+func TestPartitionByInitialFiles(t *testing.T) {
+	listing := "2023-01-02 03:04:05 1 apple.mp4\n2023-01-02 03:04:05 1 Bob.mp4\n2023-01-02 03:04:05 1 #tag.mp4\n"
+	dir, _, err := runListing(t, listing, "-partition-by-initial")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for initial, key := range map[string]string{"a": "apple.mp4", "b": "Bob.mp4", "_": "#tag.mp4"} {
+		rm := readFile(t, filepath.Join(dir, initial, "rm.sh"))
+		if strings.Count(rm, "aws s3 rm ") != 1 || !strings.Contains(rm, "/"+key+"'") {
+			t.Errorf("%s/rm.sh does not hold just %s:\n%s", initial, key, rm)
+		}
+	}
+}
+
+func TestMakefileDeps(t *testing.T) {
+	files := []FileStruct{
+		{Filename: "plain.mp4"},
+		{Filename: "my dir/a#b$c:d%e.mp4"},
+	}
+	want := "# Generated by ivyprince; local paths written by sync.sh\n" +
+		"/tmp/video/plain.mp4:\n" +
+		`/tmp/video/my\ dir/a\#b$$c\:d\%e.mp4:` + "\n"
+	if got := makefileDeps(files, defaultSyncDest); got != want {
+		t.Errorf("makefileDeps =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestScriptCommentsColumns(t *testing.T) {
+	s3Time := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := []FileStruct{
+		{S3ModificationTime: s3Time, FileSize: 1, Filename: "a", FileTimestamp: s3Time},
+		{S3ModificationTime: s3Time, FileSize: 12345678, Filename: "longer/key", FileTimestamp: s3Time.Add(-400 * 24 * time.Hour)},
+	}
+
+	header, comments := scriptComments(files, true, ageUnitsDays)
+	if !strings.HasPrefix(header, "# S3 MODIFICATION TIME") {
+		t.Errorf("header = %q", header)
+	}
+	sizeColumn, keyColumn := strings.Index(header, "SIZE"), strings.Index(header, "KEY")
+	for i, comment := range comments {
+		if got := strings.Index(comment, files[i].Filename); got != keyColumn {
+			t.Errorf("comment %q has its key at column %d, want %d", comment, got, keyColumn)
+		}
+		if comment[sizeColumn-1] != ' ' || comment[sizeColumn] == ' ' {
+			t.Errorf("comment %q has no size starting at column %d", comment, sizeColumn)
+		}
+	}
+
+	header, comments = scriptComments(files, false, ageUnitsDays)
+	if header != "" || comments[0] != "# "+describeFile(files[0], ageUnitsDays) {
+		t.Errorf("plain comments = %q, %q", header, comments)
+	}
+}
+
+// testOutputOptions are the output options run uses with every flag at its
+// default apart from the shell.
+func testOutputOptions(t *testing.T, shellName string) outputOptions {
+	t.Helper()
+	sh, err := lookupShell(shellName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates, err := parseCommandTemplates(sh, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	format, err := lookupResultFormat("json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return outputOptions{
+		shell:     sh,
+		templates: templates,
+		genRm:     true,
+		genSync:   true,
+		syncDest:  defaultSyncDest,
+		format:    format,
+	}
+}
+
+// commandLines returns the non-comment lines of a generated script.
+func commandLines(script string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(script, "\r\n", "\n"), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestWriteOutputsCommandPrefix(t *testing.T) {
+	files := []FileStruct{{Filename: "a.mp4"}, {Filename: "b/c.mp4"}}
+	const prefix = "aws-vault exec prod --"
+
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"", "aws s3 "},
+		{prefix, prefix + " aws s3 "},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		opts := testOutputOptions(t, "bash")
+		opts.commandPrefix = tt.prefix
+		if _, err := writeOutputs(context.Background(), dir, files, opts); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"rm.sh", "sync.sh"} {
+			commands := commandLines(readFile(t, filepath.Join(dir, name)))
+			if name == "rm.sh" {
+				// The banner only prints locally, so it is never prefixed
+				if len(commands) == 0 || !strings.HasPrefix(commands[0], "echo ") {
+					t.Fatalf("rm.sh does not open with its banner: %q", commands)
+				}
+				commands = commands[1:]
+			}
+			if len(commands) != len(files) {
+				t.Errorf("%s has %d commands, want %d", name, len(commands), len(files))
+			}
+			for _, command := range commands {
+				if !strings.HasPrefix(command, tt.want) {
+					t.Errorf("%s command %q does not start with %q", name, command, tt.want)
+				}
+			}
+		}
+	}
+}
+
+func TestWriteOutputsQuoting(t *testing.T) {
+	files := []FileStruct{
+		{Filename: "plain/a.mp4", FileSize: 1},
+		{Filename: "it's here.mp4", FileSize: 1},
+		{Filename: "my dir/a#b$c.mp4", FileSize: 1},
+	}
+
+	tests := []struct {
+		shell    string
+		wantRm   []string
+		wantSync []string
+	}{
+		{
+			shell: "bash",
+			wantRm: []string{
+				"echo 'About to delete 3 objects totaling 3 B'",
+				"aws s3 rm 's3://streamboxdineorb/plain/a.mp4'",
+				`aws s3 rm 's3://streamboxdineorb/it'"'"'s here.mp4'`,
+				"aws s3 rm 's3://streamboxdineorb/my dir/a#b$c.mp4'",
+			},
+			wantSync: []string{
+				"aws s3 sync 's3://streamboxdineorb' /tmp/video --exclude='*' --include='plain/a.mp4'",
+				`aws s3 sync 's3://streamboxdineorb' /tmp/video --exclude='*' --include='it'"'"'s here.mp4'`,
+				"aws s3 sync 's3://streamboxdineorb' /tmp/video --exclude='*' --include='my dir/a#b$c.mp4'",
+			},
+		},
+		{
+			shell: "powershell",
+			wantRm: []string{
+				"Write-Host 'About to delete 3 objects totaling 3 B'",
+				"aws s3 rm 's3://streamboxdineorb/plain/a.mp4'",
+				"aws s3 rm 's3://streamboxdineorb/it''s here.mp4'",
+				"aws s3 rm 's3://streamboxdineorb/my dir/a#b$c.mp4'",
+			},
+			wantSync: []string{
+				"aws s3 sync 's3://streamboxdineorb' /tmp/video '--exclude=*' '--include=plain/a.mp4'",
+				"aws s3 sync 's3://streamboxdineorb' /tmp/video '--exclude=*' '--include=it''s here.mp4'",
+				"aws s3 sync 's3://streamboxdineorb' /tmp/video '--exclude=*' '--include=my dir/a#b$c.mp4'",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.shell, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := testOutputOptions(t, tt.shell)
+			if _, err := writeOutputs(context.Background(), dir, files, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			rm := readFile(t, filepath.Join(dir, "rm"+opts.shell.extension))
+			if got := commandLines(rm); !reflect.DeepEqual(got, tt.wantRm) {
+				t.Errorf("rm script commands:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.wantRm, "\n"))
+			}
+			sync := readFile(t, filepath.Join(dir, "sync"+opts.shell.extension))
+			if got := commandLines(sync); !reflect.DeepEqual(got, tt.wantSync) {
+				t.Errorf("sync script commands:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.wantSync, "\n"))
+			}
+			if tt.shell == "powershell" && strings.Contains(strings.ReplaceAll(rm, "\r\n", ""), "\n") {
+				t.Error("powershell script has bare \\n line endings")
+			}
+		})
+	}
+}
+
+func TestRmBanner(t *testing.T) {
+	files := []FileStruct{{FileSize: 1500}, {FileSize: 500}}
+
+	tests := []struct {
+		shell string
+		sleep int
+		want  string
+	}{
+		{"bash", 5, "echo 'About to delete 2 objects totaling 2.0 kB'\nsleep 5\n"},
+		{"bash", 0, "echo 'About to delete 2 objects totaling 2.0 kB'\n"},
+		{"powershell", 3, "Write-Host 'About to delete 2 objects totaling 2.0 kB'\nStart-Sleep -Seconds 3\n"},
+	}
+	for _, tt := range tests {
+		sh := testOutputOptions(t, tt.shell).shell
+		if got := sh.rmBanner(files, tt.sleep); got != tt.want {
+			t.Errorf("%s rmBanner(sleep %d) = %q, want %q", tt.shell, tt.sleep, got, tt.want)
+		}
+	}
+}
+
+func TestRunScriptSelection(t *testing.T) {
+	listing := "2023-01-02 03:04:05 1 a.mp4\n"
+
+	tests := []struct {
+		args           []string
+		wantRm         bool
+		wantSync       bool
+		wantSyncTarget string
+	}{
+		{nil, true, true, " /tmp/video "},
+		{[]string{"-gen-rm=false"}, false, true, " /tmp/video "},
+		{[]string{"-gen-sync=false"}, true, false, ""},
+		{[]string{"-sync-dest", "/mnt/my videos"}, true, true, " '/mnt/my videos' "},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			dir, _, err := runListing(t, listing, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range map[string]bool{"rm.sh": tt.wantRm, "sync.sh": tt.wantSync} {
+				if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != want {
+					t.Errorf("%s exists = %v, want %v", name, err == nil, want)
+				}
+			}
+			if tt.wantSync {
+				sync := readFile(t, filepath.Join(dir, "sync.sh"))
+				if !strings.Contains(sync, tt.wantSyncTarget) {
+					t.Errorf("sync.sh does not download into %q:\n%s", tt.wantSyncTarget, sync)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteOutputsHideExpiring(t *testing.T) {
+	files := []FileStruct{{Filename: "old", FileSize: 5, WillExpire: true}, {Filename: "new", FileSize: 7}}
+	opts := testOutputOptions(t, "bash")
+	opts.hideExpiring = true
+
+	dir := t.TempDir()
+	if _, err := writeOutputs(context.Background(), dir, files, opts); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"echo 'About to delete 1 objects totaling 7 B'", "aws s3 rm 's3://streamboxdineorb/new'"}
+	if got := commandLines(readFile(t, filepath.Join(dir, "rm.sh"))); !reflect.DeepEqual(got, want) {
+		t.Errorf("rm.sh commands = %q, want %q", got, want)
+	}
+	if got := len(commandLines(readFile(t, filepath.Join(dir, "sync.sh")))); got != 2 {
+		t.Errorf("sync.sh has %d commands, want both files", got)
+	}
+}
+
+func TestWriteOutputsRangeBytes(t *testing.T) {
+	files := []FileStruct{{Filename: "a/b/1.mp4"}, {Filename: "a/b/2.mp4"}, {Filename: "top.mp4"}}
+
+	tests := []struct {
+		shell string
+		want  []string
+	}{
+		{"bash", []string{
+			"mkdir -p '/tmp/video/a/b'",
+			"aws s3api get-object --bucket 'streamboxdineorb' --key 'a/b/1.mp4' --range bytes=0-1023 /tmp/video/a/b/1.mp4",
+			"aws s3api get-object --bucket 'streamboxdineorb' --key 'a/b/2.mp4' --range bytes=0-1023 /tmp/video/a/b/2.mp4",
+			"mkdir -p '/tmp/video'",
+			"aws s3api get-object --bucket 'streamboxdineorb' --key 'top.mp4' --range bytes=0-1023 /tmp/video/top.mp4",
+		}},
+		{"powershell", []string{
+			"New-Item -ItemType Directory -Force -Path '/tmp/video/a/b' | Out-Null",
+			"aws s3api get-object --bucket 'streamboxdineorb' --key 'a/b/1.mp4' --range bytes=0-1023 /tmp/video/a/b/1.mp4",
+			"aws s3api get-object --bucket 'streamboxdineorb' --key 'a/b/2.mp4' --range bytes=0-1023 /tmp/video/a/b/2.mp4",
+			"New-Item -ItemType Directory -Force -Path '/tmp/video' | Out-Null",
+			"aws s3api get-object --bucket 'streamboxdineorb' --key 'top.mp4' --range bytes=0-1023 /tmp/video/top.mp4",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.shell, func(t *testing.T) {
+			opts := testOutputOptions(t, tt.shell)
+			templates, err := parseCommandTemplates(opts.shell, "", rangeSyncTemplate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts.templates = templates
+			opts.byteRange = "0-1023"
+			opts.genRm = false
+
+			dir := t.TempDir()
+			if _, err := writeOutputs(context.Background(), dir, files, opts); err != nil {
+				t.Fatal(err)
+			}
+			got := commandLines(readFile(t, filepath.Join(dir, "sync"+opts.shell.extension)))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sync script commands:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestWriteOutputsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	opts := testOutputOptions(t, "bash")
+	dir := t.TempDir()
+	written, err := writeOutputs(ctx, dir, []FileStruct{{Filename: "a", FileSize: 1}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 0 {
+		t.Errorf("wrote %d files after cancellation, want none", len(written))
+	}
+	want := []string{"echo 'About to delete 0 objects totaling 0 B'"}
+	if got := commandLines(readFile(t, filepath.Join(dir, "rm.sh"))); !reflect.DeepEqual(got, want) {
+		t.Errorf("rm.sh commands = %q, want only the banner %q", got, want)
+	}
+	if got := strings.TrimSpace(readFile(t, filepath.Join(dir, "results.json"))); got != "[]" {
+		t.Errorf("results.json = %s, want no files", got)
+	}
+}
+
+func TestWriteOutputsCommandPrefixRangeBytes(t *testing.T) {
+	files := []FileStruct{{Filename: "a/1.mp4"}, {Filename: "a/2.mp4"}, {Filename: "top.mp4"}}
+	const prefix = "aws-vault exec prod --"
+
+	opts := testOutputOptions(t, "bash")
+	templates, err := parseCommandTemplates(opts.shell, "", rangeSyncTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.templates = templates
+	opts.byteRange = "0-1023"
+	opts.commandPrefix = prefix
+
+	dir := t.TempDir()
+	if _, err := writeOutputs(context.Background(), dir, files, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	var mkdirs, downloads int
+	for _, command := range commandLines(readFile(t, filepath.Join(dir, "sync.sh"))) {
+		switch {
+		case strings.HasPrefix(command, "mkdir -p "):
+			// Directories are created locally, so they are never prefixed
+			mkdirs++
+		case strings.HasPrefix(command, prefix+" aws s3api get-object "):
+			downloads++
+		default:
+			t.Errorf("sync.sh command %q is neither a mkdir nor a prefixed download", command)
+		}
+	}
+	if mkdirs != 2 || downloads != len(files) {
+		t.Errorf("sync.sh has %d mkdirs and %d downloads, want 2 and %d", mkdirs, downloads, len(files))
+	}
+}
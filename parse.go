@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gzipMagic is the header every gzip stream starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// defaultMaxLineSize is the default longest input line readListing accepts.
+const defaultMaxLineSize = 1024 * 1024
+
+// parseOptions controls how readListing interprets its input.
+type parseOptions struct {
+	maxLineSize int
+
+	// concurrency is the number of goroutines parsing lines; 1 or less
+	// parses on the reading goroutine.
+	concurrency int
+
+	// location is the time zone for display. S3 times, which are UTC, are
+	// converted to it, and timestamps embedded in filenames are read in it.
+	location *time.Location
+
+	// timestampPatterns are the filename timestamp formats recognised, in
+	// the order they are tried. None means every file uses its S3 time.
+	timestampPatterns []timestampPattern
+
+	// delimiter splits lines on an exact separator, preserving empty
+	// fields. Empty means split on runs of whitespace.
+	delimiter string
+
+	// strictTimestamps also rejects implausible embedded timestamps, then
+	// keeps files with a bad one flagged and using their S3 time.
+	strictTimestamps bool
+
+	// excludeBadTimestamps drops files with an unparseable or implausible
+	// embedded timestamp as failed lines, overriding strictTimestamps.
+	excludeBadTimestamps bool
+
+	// strict makes the first line that fails to parse an error instead of
+	// logging and skipping it.
+	strict bool
+}
+
+// listingStats counts input lines that did not become files, and files kept
+// despite a bad embedded timestamp.
+type listingStats struct {
+	Lines    int                    `json:"lines"`
+	Prefixes int                    `json:"prefixes_skipped"`
+	Failed   int                    `json:"lines_failed"`
+	Flagged  int                    `json:"timestamps_flagged,omitempty"`
+	Errors   map[parseErrorKind]int `json:"parse_errors,omitempty"`
+}
+
+func (s *listingStats) add(other listingStats) {
+	s.Lines += other.Lines
+	s.Prefixes += other.Prefixes
+	s.Failed += other.Failed
+	s.Flagged += other.Flagged
+	for kind, count := range other.Errors {
+		if s.Errors == nil {
+			s.Errors = make(map[parseErrorKind]int)
+		}
+		s.Errors[kind] += count
+	}
+}
+
+// errorRate is the fraction of non-blank lines that failed to parse.
+func (s listingStats) errorRate() float64 {
+	if s.Lines == 0 {
+		return 0
+	}
+	return float64(s.Failed) / float64(s.Lines)
+}
+
+func (s listingStats) String() string {
+	line := fmt.Sprintf("%d lines read, %d failed to parse (%.1f%%)", s.Lines, s.Failed, 100*s.errorRate())
+	if s.Flagged > 0 {
+		line += fmt.Sprintf(", %d kept with a bad file timestamp", s.Flagged)
+	}
+	return line
+}
+
+// parseErrorKind classifies why a listing line was rejected.
+type parseErrorKind string
+
+const (
+	errShortLine        parseErrorKind = "short_line"
+	errBadTimestamp     parseErrorKind = "bad_timestamp"
+	errBadSize          parseErrorKind = "bad_size"
+	errBadFileTimestamp parseErrorKind = "bad_file_timestamp"
+
+	errImplausibleFileTimestamp parseErrorKind = "implausible_file_timestamp"
+)
+
+// parseError is returned by parseLine for a line that cannot be used.
+type parseError struct {
+	kind parseErrorKind
+	err  error
+}
+
+func (e *parseError) Error() string { return e.err.Error() }
+func (e *parseError) Unwrap() error { return e.err }
+
+// readListing parses `aws s3 ls` output, which may be gzip-compressed. Lines
+// that fail to parse are logged, citing name as their source, and skipped, as
+// are the "PRE" lines listing common prefixes; with opts.strict the first
+// such failure is returned instead. Reading stops early, without error, once
+// ctx is done.
+func readListing(ctx context.Context, name string, r io.Reader, opts parseOptions, logger *log.Logger) ([]FileStruct, listingStats, error) {
+	var stats listingStats
+
+	r, err := maybeGunzip(r)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var files []FileStruct
+	var strictErr error
+	collect := func(lineNumber int, line string, result lineResult) {
+		if strings.TrimSpace(line) == "" || strictErr != nil {
+			return
+		}
+		if opts.strict && result.err != nil && !isPrefixLine(line) {
+			strictErr = fmt.Errorf("failed to parse %s line %d '%s': %w", name, lineNumber, line, result.err)
+			cancel()
+			return
+		}
+		stats.Lines++
+
+		switch {
+		case isPrefixLine(line):
+			stats.Prefixes++
+		case result.warning != nil:
+			stats.Flagged++
+			logger.Printf("Warning: %s line %d '%s': %v; using S3 time", name, lineNumber, line, result.warning)
+			files = append(files, result.file)
+		case result.err != nil:
+			stats.Failed++
+			var perr *parseError
+			if errors.As(result.err, &perr) {
+				if stats.Errors == nil {
+					stats.Errors = make(map[parseErrorKind]int)
+				}
+				stats.Errors[perr.kind]++
+			}
+			logger.Printf("Error parsing %s line %d '%s': %v", name, lineNumber, line, result.err)
+		default:
+			files = append(files, result.file)
+		}
+	}
+
+	var lineNumber int
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, opts.maxLineSize)
+	if opts.concurrency > 1 {
+		lineNumber = parseConcurrently(ctx, scanner, opts, collect)
+	} else {
+		for scanner.Scan() {
+			if ctx.Err() != nil {
+				break
+			}
+			lineNumber++
+
+			line := scanner.Text()
+			collect(lineNumber, line, parseListingLine(line, opts))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, stats, fmt.Errorf("%s line %d is longer than the %d byte limit; raise -max-line-size: %w",
+				name, lineNumber+1, opts.maxLineSize, err)
+		}
+		return nil, stats, err
+	}
+	if strictErr != nil {
+		return nil, stats, strictErr
+	}
+	return files, stats, nil
+}
+
+// lineResult is the outcome of parsing one listing line. A line with a
+// warning still produced file.
+type lineResult struct {
+	file    FileStruct
+	err     error
+	warning error
+}
+
+// parseListingLine parses line unless it is blank or a PRE line, which the
+// caller recognises and skips on its own. Under -strict-timestamps, a bad
+// embedded timestamp is downgraded to a warning on the flagged file.
+func parseListingLine(line string, opts parseOptions) lineResult {
+	if strings.TrimSpace(line) == "" || isPrefixLine(line) {
+		return lineResult{}
+	}
+	file, err := parseLine(line, opts)
+	var perr *parseError
+	if opts.strictTimestamps && !opts.excludeBadTimestamps && errors.As(err, &perr) &&
+		(perr.kind == errBadFileTimestamp || perr.kind == errImplausibleFileTimestamp) {
+		file.TimestampFlagged = true
+		return lineResult{file: file, warning: err}
+	}
+	return lineResult{file: file, err: err}
+}
+
+// concurrencyBatchSize is how many lines are handed to a worker at a time,
+// which keeps channel overhead small relative to the parsing work.
+const concurrencyBatchSize = 1024
+
+// parseConcurrently reads lines sequentially from scanner and parses them on
+// opts.concurrency workers. Results are passed to collect in input order
+// from a single goroutine, so line numbers, logging and the order of files
+// match the sequential path. It returns the number of lines read.
+func parseConcurrently(ctx context.Context, scanner *bufio.Scanner, opts parseOptions, collect func(int, string, lineResult)) int {
+	type batch struct {
+		index     int
+		firstLine int
+		lines     []string
+		results   []lineResult
+	}
+
+	jobs := make(chan *batch, opts.concurrency)
+	parsed := make(chan *batch, opts.concurrency)
+
+	var workers sync.WaitGroup
+	for i := 0; i < opts.concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for b := range jobs {
+				b.results = make([]lineResult, len(b.lines))
+				for i, line := range b.lines {
+					b.results[i] = parseListingLine(line, opts)
+				}
+				parsed <- b
+			}
+		}()
+	}
+
+	// Hand batches to collect in order, holding back any that finish early
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		pending := make(map[int]*batch)
+		next := 0
+		for b := range parsed {
+			pending[b.index] = b
+			for ready, ok := pending[next]; ok; ready, ok = pending[next] {
+				delete(pending, next)
+				for i, result := range ready.results {
+					collect(ready.firstLine+i, ready.lines[i], result)
+				}
+				next++
+			}
+		}
+	}()
+
+	lineNumber := 0
+	current := &batch{firstLine: 1}
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			break
+		}
+		lineNumber++
+
+		current.lines = append(current.lines, scanner.Text())
+		if len(current.lines) == concurrencyBatchSize {
+			jobs <- current
+			current = &batch{index: current.index + 1, firstLine: lineNumber + 1}
+		}
+	}
+	if len(current.lines) > 0 {
+		jobs <- current
+	}
+
+	close(jobs)
+	workers.Wait()
+	close(parsed)
+	<-collected
+	return lineNumber
+}
+
+// readListingFile is readListing for the listing stored at path.
+func readListingFile(ctx context.Context, path string, opts parseOptions, logger *log.Logger) ([]FileStruct, listingStats, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, listingStats{}, err
+	}
+	defer file.Close()
+
+	return readListing(ctx, path, file, opts, logger)
+}
+
+// readListingFiles reads each listing in turn and merges their files and
+// stats in the order given.
+func readListingFiles(ctx context.Context, paths []string, opts parseOptions, logger *log.Logger) ([]FileStruct, listingStats, error) {
+	var files []FileStruct
+	var stats listingStats
+	for _, path := range paths {
+		pathFiles, pathStats, err := readListingFile(ctx, path, opts, logger)
+		if err != nil {
+			return nil, stats, err
+		}
+		files = append(files, pathFiles...)
+		stats.add(pathStats)
+	}
+	return files, stats, nil
+}
+
+// isPrefixLine reports whether line is a "PRE name/" pseudo-directory entry,
+// which `aws s3 ls` prints without a timestamp or size.
+func isPrefixLine(line string) bool {
+	return strings.HasPrefix(strings.TrimLeft(line, " \t"), "PRE ")
+}
+
+// parseLine parses a single "date time size key" listing line.
+func parseLine(line string, opts parseOptions) (FileStruct, error) {
+	var fields []string
+	separator := " "
+	if opts.delimiter != "" {
+		fields = strings.Split(line, opts.delimiter)
+		separator = opts.delimiter
+	} else {
+		fields = strings.Fields(line)
+	}
+	if len(fields) < 4 {
+		return FileStruct{}, &parseError{errShortLine, fmt.Errorf("expected date, time, size and key but found %d fields", len(fields))}
+	}
+
+	s3Timestamp, err := time.Parse("2006-01-02 15:04:05", fmt.Sprintf("%s %s", fields[0], fields[1]))
+	if err != nil {
+		return FileStruct{}, &parseError{errBadTimestamp, fmt.Errorf("invalid S3 modification timestamp: %w", err)}
+	}
+	s3Timestamp = s3Timestamp.In(opts.location)
+
+	fileSize, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return FileStruct{}, &parseError{errBadSize, fmt.Errorf("invalid file size: %w", err)}
+	}
+	filename := strings.Join(fields[3:], separator)
+
+	file := FileStruct{
+		S3ModificationTime: s3Timestamp,
+		FileSize:           fileSize,
+		Filename:           filename,
+		FileTimestamp:      s3Timestamp,
+		TimestampSource:    timestampSourceS3,
+	}
+
+	// Timestamp errors come back with file still set, falling back to S3
+	// time, so -strict-timestamps can keep it
+	fileTimestamp, embedded, err := extractFileTimestamp(filename, s3Timestamp, opts.timestampPatterns)
+	if err != nil {
+		return file, &parseError{errBadFileTimestamp, fmt.Errorf("invalid file timestamp: %w", err)}
+	}
+	if embedded {
+		if opts.strictTimestamps || opts.excludeBadTimestamps {
+			if err := checkFileTimestamp(fileTimestamp, s3Timestamp); err != nil {
+				return file, &parseError{errImplausibleFileTimestamp, fmt.Errorf("implausible file timestamp: %w", err)}
+			}
+		}
+		file.FileTimestamp = fileTimestamp
+		file.TimestampSource = timestampSourceFilename
+	}
+	return file, nil
+}
+
+// parseDelimiter interprets Go escapes such as `\t` in a -delimiter value so
+// tabs can be passed without shell quoting tricks.
+func parseDelimiter(value string) string {
+	if unquoted, err := strconv.Unquote(`"` + value + `"`); err == nil {
+		return unquoted
+	}
+	return value
+}
+
+// maybeGunzip sniffs r for the gzip magic bytes and, if present, returns a
+// decompressing reader. Other input is returned unchanged.
+func maybeGunzip(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(len(gzipMagic))
+	if err != nil || !bytes.Equal(magic, gzipMagic) {
+		return buffered, nil
+	}
+
+	gz, err := gzip.NewReader(buffered)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gzip input: %w", err)
+	}
+	return gz, nil
+}
+
+// timestampPattern is one filename timestamp format extractFileTimestamp
+// recognises.
+type timestampPattern struct {
+	name   string
+	regexp *regexp.Regexp
+	layout string
+}
+
+// timestampPatterns are the filename timestamp formats -timestamp-patterns
+// can choose from. They are compiled once since extractFileTimestamp runs for
+// every input line.
+var timestampPatterns = []timestampPattern{
+	{"compact", regexp.MustCompile(`\d{8}_\d{6}`), "20060102_150405"},
+	{"basic", regexp.MustCompile(`\d{8}T\d{6}`), "20060102T150405"},
+	{"dashed", regexp.MustCompile(`\d{4}-\d{2}-\d{2}_\d{2}-\d{2}-\d{2}`), "2006-01-02_15-04-05"},
+}
+
+// defaultTimestampPattern is the only format recognised unless
+// -timestamp-patterns says otherwise.
+const defaultTimestampPattern = "compact"
+
+// lookupTimestampPatterns returns the named patterns in the order given.
+func lookupTimestampPatterns(names []string) ([]timestampPattern, error) {
+	var patterns []timestampPattern
+	for _, name := range names {
+		i := indexTimestampPattern(name)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid timestamp pattern '%s': use 'compact', 'basic' or 'dashed'", name)
+		}
+		patterns = append(patterns, timestampPatterns[i])
+	}
+	return patterns, nil
+}
+
+func indexTimestampPattern(name string) int {
+	for i, pattern := range timestampPatterns {
+		if pattern.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// matchTimestampPattern returns the first of patterns found in filename and
+// the text it matched, or nil when there is none.
+func matchTimestampPattern(filename string, patterns []timestampPattern) (*timestampPattern, string) {
+	for i := range patterns {
+		if match := patterns[i].regexp.FindString(filename); match != "" {
+			return &patterns[i], match
+		}
+	}
+	return nil, ""
+}
+
+// fileTimestampSlack is how far an embedded timestamp may run ahead of the S3
+// modification time before -strict-timestamps or -exclude-bad-timestamps
+// rejects it, allowing for clocks and time zones that disagree.
+const fileTimestampSlack = 24 * time.Hour
+
+// checkFileTimestamp rejects an embedded timestamp that predates the Unix
+// epoch or is later than the upload it names.
+func checkFileTimestamp(fileTimestamp, s3Timestamp time.Time) error {
+	if fileTimestamp.Before(time.Unix(0, 0)) {
+		return fmt.Errorf("file timestamp %s is before 1970", fileTimestamp.Format(time.RFC3339))
+	}
+	if fileTimestamp.After(s3Timestamp.Add(fileTimestampSlack)) {
+		return fmt.Errorf("file timestamp %s is after the S3 modification time %s",
+			fileTimestamp.Format(time.RFC3339), s3Timestamp.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// extractFileTimestamp returns the timestamp embedded in filename in any of
+// patterns, read in the same location as s3Timestamp, or s3Timestamp when
+// there is none. The bool reports whether the timestamp came from the
+// filename.
+func extractFileTimestamp(filename string, s3Timestamp time.Time, patterns []timestampPattern) (time.Time, bool, error) {
+	// Find the timestamp in the filename
+	pattern, timestampStr := matchTimestampPattern(filename, patterns)
+	if pattern != nil {
+		// Parse the timestamp
+		fileTimestamp, err := time.ParseInLocation(pattern.layout, timestampStr, s3Timestamp.Location())
+		if err != nil {
+			return s3Timestamp, false, fmt.Errorf("unable to parse file timestamp: %v", err)
+		}
+
+		return fileTimestamp, true, nil
+	}
+
+	// Return the S3 timestamp if the file timestamp is not found in the filename
+	return s3Timestamp, false, nil
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testParseOptions are the parse options run uses with every flag at its
+// default.
+func testParseOptions(t *testing.T) parseOptions {
+	t.Helper()
+	patterns, err := lookupTimestampPatterns([]string{defaultTimestampPattern})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseOptions{
+		maxLineSize:       defaultMaxLineSize,
+		concurrency:       1,
+		location:          time.UTC,
+		timestampPatterns: patterns,
+	}
+}
+
+var discardLogger = log.New(io.Discard, "", 0)
+
+func mustTime(t *testing.T, layout, value string) time.Time {
+	t.Helper()
+	ts, err := time.Parse(layout, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+func TestReadListing(t *testing.T) {
+	s3Time := mustTime(t, time.DateTime, "2023-01-02 03:04:05")
+	listing := "                           PRE a/\n" +
+		"2023-01-02 03:04:05       1234 a/20230101_120000.mp4\n" +
+		"2023-01-02 03:04:05 lots bad-size.txt\n" +
+		"\tPRE my dir/\n" +
+		"2023-01-02 03:04:05         99 my dir/notes.txt\n"
+
+	files, stats, err := readListing(context.Background(), "list.txt", strings.NewReader(listing), testParseOptions(t), discardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Prefixes != 2 {
+		t.Errorf("counted %d PRE lines, want 2", stats.Prefixes)
+	}
+	want := []FileStruct{
+		{
+			S3ModificationTime: s3Time, FileSize: 1234, Filename: "a/20230101_120000.mp4",
+			FileTimestamp: mustTime(t, time.DateTime, "2023-01-01 12:00:00"), TimestampSource: timestampSourceFilename,
+		},
+		{S3ModificationTime: s3Time, FileSize: 99, Filename: "my dir/notes.txt", FileTimestamp: s3Time, TimestampSource: timestampSourceS3},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("readListing = %+v, want %+v", files, want)
+	}
+}
+
+func TestReadListingCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	files, _, err := readListing(ctx, "list.txt", strings.NewReader("2023-01-02 03:04:05 99 a.txt\n"), testParseOptions(t), discardLogger)
+	if err != nil || len(files) != 0 {
+		t.Errorf("readListing after cancellation = %d files, %v; want none", len(files), err)
+	}
+}
+
+const testListing = `2023-01-02 03:04:05       1234 apple/20230101_120000.mp4
+                           PRE apple/
+2023-01-02 03:04:05         99 Bob.json
+2023-01-03 03:04:05          0 .hidden
+`
+
+func TestReadListingGzip(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write([]byte(testListing)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	plainFiles, plainStats, err := readListing(context.Background(), "list.txt", strings.NewReader(testListing), testParseOptions(t), discardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzFiles, gzStats, err := readListing(context.Background(), "list.txt", &compressed, testParseOptions(t), discardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plainFiles) != 3 || !reflect.DeepEqual(gzFiles, plainFiles) || !reflect.DeepEqual(gzStats, plainStats) {
+		t.Errorf("gzip input parsed to %+v %+v, plain to %+v %+v", gzFiles, gzStats, plainFiles, plainStats)
+	}
+
+	// Input shorter than the magic bytes is not mistaken for gzip
+	if files, _, err := readListing(context.Background(), "list.txt", strings.NewReader(""), testParseOptions(t), discardLogger); err != nil || len(files) != 0 {
+		t.Errorf("empty input = %d files, %v", len(files), err)
+	}
+}
+
+func TestReadListingLineLength(t *testing.T) {
+	longKey := strings.Repeat("k", 100*1024)
+	listing := "2023-01-02 03:04:05 99 " + longKey + "\n"
+
+	tests := []struct {
+		name        string
+		maxLineSize int
+		wantErr     bool
+	}{
+		{"longer than bufio default", defaultMaxLineSize, false},
+		{"over -max-line-size", 64 * 1024, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testParseOptions(t)
+			opts.maxLineSize = tt.maxLineSize
+			files, _, err := readListing(context.Background(), "list.txt", strings.NewReader(listing), opts, discardLogger)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "list.txt line 1 is longer") || !strings.Contains(err.Error(), "-max-line-size") {
+					t.Fatalf("error = %v, want a hint to raise -max-line-size", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 || files[0].Filename != longKey {
+				t.Errorf("got %d files, want the one long key", len(files))
+			}
+		})
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	s3Time := mustTime(t, time.DateTime, "2023-01-02 03:04:05")
+	nameTime := mustTime(t, time.DateTime, "2023-01-01 12:00:00")
+
+	tests := []struct {
+		name      string
+		line      string
+		delimiter string
+		want      FileStruct
+		wantErr   string
+		wantKind  parseErrorKind
+	}{
+		{
+			name: "embedded timestamp",
+			line: "2023-01-02 03:04:05       1234 a/20230101_120000.mp4",
+			want: FileStruct{
+				S3ModificationTime: s3Time, FileSize: 1234, Filename: "a/20230101_120000.mp4",
+				FileTimestamp: nameTime, TimestampSource: timestampSourceFilename,
+			},
+		},
+		{
+			name: "key with spaces",
+			line: "2023-01-02 03:04:05 99 my dir/a b.txt",
+			want: FileStruct{S3ModificationTime: s3Time, FileSize: 99, Filename: "my dir/a b.txt", FileTimestamp: s3Time, TimestampSource: timestampSourceS3},
+		},
+		{
+			name:      "tab delimiter keeps repeated spaces",
+			line:      "2023-01-02\t03:04:05\t99\ta  b.txt",
+			delimiter: "\t",
+			want:      FileStruct{S3ModificationTime: s3Time, FileSize: 99, Filename: "a  b.txt", FileTimestamp: s3Time, TimestampSource: timestampSourceS3},
+		},
+		{
+			name:      "tab delimiter keeps tabs in the key",
+			line:      "2023-01-02\t03:04:05\t99\ta\tb.txt",
+			delimiter: "\t",
+			want:      FileStruct{S3ModificationTime: s3Time, FileSize: 99, Filename: "a\tb.txt", FileTimestamp: s3Time, TimestampSource: timestampSourceS3},
+		},
+		{
+			name: "tab delimiter on spaced input", line: "2023-01-02 03:04:05 99 a.txt", delimiter: "\t",
+			wantErr: "found 1 fields", wantKind: errShortLine,
+		},
+		{name: "short line", line: "2023-01-02 03:04:05 99", wantErr: "found 3 fields", wantKind: errShortLine},
+		{name: "bad s3 timestamp", line: "2023-13-02 03:04:05 99 a.txt", wantErr: "invalid S3 modification timestamp", wantKind: errBadTimestamp},
+		{name: "bad size", line: "2023-01-02 03:04:05 lots a.txt", wantErr: "invalid file size", wantKind: errBadSize},
+		{
+			name: "bad file timestamp", line: "2023-01-02 03:04:05 99 a/20231301_120000.mp4",
+			wantErr: "invalid file timestamp", wantKind: errBadFileTimestamp,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testParseOptions(t)
+			opts.delimiter = tt.delimiter
+			got, err := parseLine(tt.line, opts)
+			if tt.wantErr != "" {
+				var perr *parseError
+				if !errors.As(err, &perr) || perr.kind != tt.wantKind || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseLine(%q) error = %v, want %s %q", tt.line, err, tt.wantKind, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseLine(%q) error = %v", tt.line, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLineTimeZone(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database unavailable:", err)
+	}
+	opts := testParseOptions(t)
+	opts.location = location
+
+	file, err := parseLine("2023-01-02 03:04:05 99 a/20230101_120000.mp4", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := file.S3ModificationTime.Format(time.DateTime), "2023-01-01 22:04:05"; got != want {
+		t.Errorf("S3 time in New York = %s, want %s", got, want)
+	}
+	if got, want := file.FileTimestamp.Format(time.RFC3339), "2023-01-01T12:00:00-05:00"; got != want {
+		t.Errorf("file timestamp = %s, want %s", got, want)
+	}
+}
+
+func TestExtractFileTimestampPatterns(t *testing.T) {
+	s3Time := mustTime(t, time.DateTime, "2023-06-01 00:00:00")
+
+	tests := []struct {
+		patterns []string
+		filename string
+		want     string
+		embedded bool
+	}{
+		{[]string{"compact"}, "a/20230101_120000.mp4", "2023-01-01 12:00:00", true},
+		{[]string{"compact"}, "a/20230101T120000.mp4", "2023-06-01 00:00:00", false},
+		{[]string{"compact", "basic"}, "a/20230101T120000.mp4", "2023-01-01 12:00:00", true},
+		{[]string{"dashed"}, "a/2023-01-01_12-00-00.mp4", "2023-01-01 12:00:00", true},
+		{[]string{"basic", "compact"}, "a/20230101_120000-then-20230102T000000.mp4", "2023-01-02 00:00:00", true},
+		{nil, "a/20230101_120000.mp4", "2023-06-01 00:00:00", false},
+	}
+	for _, tt := range tests {
+		patterns, err := lookupTimestampPatterns(tt.patterns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, embedded, err := extractFileTimestamp(tt.filename, s3Time, patterns)
+		if err != nil {
+			t.Fatalf("extractFileTimestamp(%q, %v) error = %v", tt.filename, tt.patterns, err)
+		}
+		if got.Format(time.DateTime) != tt.want || embedded != tt.embedded {
+			t.Errorf("extractFileTimestamp(%q, %v) = %s, %t; want %s, %t",
+				tt.filename, tt.patterns, got.Format(time.DateTime), embedded, tt.want, tt.embedded)
+		}
+	}
+
+	if _, err := lookupTimestampPatterns([]string{"iso"}); err == nil {
+		t.Error("lookupTimestampPatterns accepted an unknown pattern")
+	}
+}
+
+func TestParseDelimiter(t *testing.T) {
+	tests := map[string]string{
+		`\t`: "\t",
+		`|`:  "|",
+		`,`:  ",",
+		`\"`: `"`,
+	}
+	for value, want := range tests {
+		if got := parseDelimiter(value); got != want {
+			t.Errorf("parseDelimiter(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestReadListingErrorCategories(t *testing.T) {
+	listing := "2023-01-02 03:04:05 1 good.txt\n" +
+		"2023-01-02 03:04:05 1\n" +
+		"short\n" +
+		"2023-01-02 03:04:05 lots big.txt\n" +
+		"2023-01-02 25:04:05 1 late.txt\n" +
+		"2023-01-02 03:04:05 1 a/20231301_120000.mp4\n" +
+		"2023-01-02 03:04:05 1 b/20230132_120000.mp4\n" +
+		"2023-01-02 03:04:05 1 c/20230101_250000.mp4\n"
+
+	files, stats, err := readListing(context.Background(), "list.txt", strings.NewReader(listing), testParseOptions(t), discardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %d files, want 1", len(files))
+	}
+	want := map[parseErrorKind]int{errShortLine: 2, errBadSize: 1, errBadTimestamp: 1, errBadFileTimestamp: 3}
+	if !reflect.DeepEqual(stats.Errors, want) {
+		t.Errorf("error categories = %v, want %v", stats.Errors, want)
+	}
+}
+
+// syntheticListing builds an n-line listing that mixes embedded and
+// fallback timestamps with PRE markers and bad lines, spanning several
+// concurrency batches.
+func syntheticListing(n int) string {
+	var out strings.Builder
+	base := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		s3Time := base.Add(time.Duration(i) * time.Minute).Format(time.DateTime)
+		switch i % 10 {
+		case 0:
+			fmt.Fprintf(&out, "                           PRE dir%d/\n", i)
+		case 1:
+			fmt.Fprintf(&out, "%s %d\n", s3Time, i)
+		case 2:
+			fmt.Fprintf(&out, "%s %10d dir%d/notes-%d.txt\n", s3Time, i, i%7, i)
+		case 3:
+			fmt.Fprintf(&out, "%s %10d dir%d/20231301_120000-%d.mp4\n", s3Time, i, i%7, i)
+		default:
+			fileTime := base.Add(-time.Duration(i) * time.Second).Format("20060102_150405")
+			fmt.Fprintf(&out, "%s %10d dir%d/%s-%d.mp4\n", s3Time, i, i%7, fileTime, i)
+		}
+	}
+	return out.String()
+}
+
+func TestReadListingConcurrentMatchesSequential(t *testing.T) {
+	listing := syntheticListing(5*concurrencyBatchSize + 17)
+
+	opts := testParseOptions(t)
+	wantFiles, wantStats, err := readListing(context.Background(), "list.txt", strings.NewReader(listing), opts, discardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, concurrency := range []int{2, 4, 16} {
+		opts.concurrency = concurrency
+		files, stats, err := readListing(context.Background(), "list.txt", strings.NewReader(listing), opts, discardLogger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(files, wantFiles) {
+			t.Errorf("concurrency %d: %d files differ from the sequential %d", concurrency, len(files), len(wantFiles))
+		}
+		if !reflect.DeepEqual(stats, wantStats) {
+			t.Errorf("concurrency %d: stats = %+v, want %+v", concurrency, stats, wantStats)
+		}
+	}
+}
+
+func TestRunConcurrentMatchesSequential(t *testing.T) {
+	listing := syntheticListing(3*concurrencyBatchSize + 5)
+
+	type output struct {
+		doc      results
+		rm, sync []string
+	}
+	outputs := make(map[string]output)
+	for _, concurrency := range []string{"1", "8"} {
+		dir, _, err := runListing(t, listing, "-concurrency", concurrency, "-format", "json-document")
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs[concurrency] = output{
+			doc:  readResultsDocument(t, filepath.Join(dir, "results.json")),
+			rm:   commandLines(readFile(t, filepath.Join(dir, "rm.sh"))),
+			sync: commandLines(readFile(t, filepath.Join(dir, "sync.sh"))),
+		}
+	}
+	if !reflect.DeepEqual(outputs["1"], outputs["8"]) {
+		t.Error("outputs differ between -concurrency 1 and 8")
+	}
+}
+
+func BenchmarkReadListing(b *testing.B) {
+	listing := syntheticListing(200_000)
+	patterns, err := lookupTimestampPatterns([]string{defaultTimestampPattern})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, concurrency := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			opts := parseOptions{
+				maxLineSize:       defaultMaxLineSize,
+				concurrency:       concurrency,
+				location:          time.UTC,
+				timestampPatterns: patterns,
+			}
+			b.SetBytes(int64(len(listing)))
+			for i := 0; i < b.N; i++ {
+				if _, _, err := readListing(context.Background(), "list.txt", strings.NewReader(listing), opts, discardLogger); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReadListingFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+	if err := os.WriteFile(first, []byte("2023-01-02 03:04:05 1 b.mp4\n                           PRE dir/\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(second, []byte("2023-01-01 03:04:05 2 a.mp4\nshort line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	files, stats, err := readListingFiles(context.Background(), []string{first, second}, testParseOptions(t), log.New(&logs, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := filenames(files), []string{"b.mp4", "a.mp4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged files = %q, want %q", got, want)
+	}
+	if want := (listingStats{Lines: 4, Failed: 1, Prefixes: 1, Errors: map[parseErrorKind]int{errShortLine: 1}}); !reflect.DeepEqual(stats, want) {
+		t.Errorf("merged stats = %+v, want %+v", stats, want)
+	}
+	if !strings.Contains(logs.String(), "Error parsing "+second+" line 2 ") {
+		t.Errorf("parse error does not name its source file:\n%s", logs.String())
+	}
+
+	_, _, err = readListingFiles(context.Background(), []string{first, filepath.Join(dir, "missing.txt")}, testParseOptions(t), discardLogger)
+	if err == nil || !strings.Contains(err.Error(), "missing.txt") {
+		t.Errorf("error for a missing listing = %v", err)
+	}
+}
+
+func TestRunMergesListings(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"a", "b", "c"} {
+		path := filepath.Join(dir, name+".txt")
+		if err := os.WriteFile(path, []byte("2023-01-02 03:04:05 1 "+name+".mp4\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	for _, args := range [][]string{
+		{"-file", paths[0], "-file", paths[1], "-file", paths[2]},
+		{"-file", paths[0] + "," + paths[1], "-file", paths[2]},
+	} {
+		out := t.TempDir()
+		var stdout, logs bytes.Buffer
+		if err := run(append(args, "-output-dir", out), &stdout, &logs); err != nil {
+			t.Fatal(err)
+		}
+		rm := readFile(t, filepath.Join(out, "rm.sh"))
+		if strings.Count(rm, "aws s3 rm ") != 3 {
+			t.Errorf("run(%q) did not merge all three listings:\n%s", args, rm)
+		}
+	}
+}
+
+func TestReadListingStrict(t *testing.T) {
+	listing := "2023-01-02 03:04:05 99 a.txt\nbad line\n2023-01-02 03:04:05 99 b.txt\n"
+
+	for _, concurrency := range []int{1, 4} {
+		opts := testParseOptions(t)
+		opts.concurrency = concurrency
+
+		files, stats, err := readListing(context.Background(), "list.txt", strings.NewReader(listing), opts, discardLogger)
+		if err != nil {
+			t.Fatalf("lenient: %v", err)
+		}
+		if len(files) != 2 || stats.Failed != 1 {
+			t.Errorf("lenient: got %d files and %d failures, want 2 and 1", len(files), stats.Failed)
+		}
+
+		opts.strict = true
+		_, _, err = readListing(context.Background(), "list.txt", strings.NewReader(listing), opts, discardLogger)
+		if err == nil || !strings.Contains(err.Error(), "list.txt line 2 'bad line'") {
+			t.Errorf("strict with concurrency %d: error = %v, want it to cite line 2", concurrency, err)
+		}
+	}
+}
+
+func TestCheckFileTimestamp(t *testing.T) {
+	s3Time := mustTime(t, time.DateTime, "2023-01-02 03:04:05")
+
+	tests := []struct {
+		fileTime string
+		wantErr  bool
+	}{
+		{"2023-01-01 12:00:00", false},
+		{"2023-01-03 03:04:05", false},
+		{"2023-01-03 03:04:06", true},
+		{"1969-12-31 23:59:59", true},
+		{"1970-01-01 00:00:00", false},
+	}
+	for _, tt := range tests {
+		err := checkFileTimestamp(mustTime(t, time.DateTime, tt.fileTime), s3Time)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkFileTimestamp(%s) = %v, want error %t", tt.fileTime, err, tt.wantErr)
+		}
+	}
+}
+
+func TestReadListingStrictTimestamps(t *testing.T) {
+	listing := "2023-01-02 03:04:05 10 a/20230101_120000.mp4\n" +
+		"2023-01-02 03:04:05 10 a/20250101_120000.mp4\n" +
+		"2023-01-02 03:04:05 10 a/20231301_120000.mp4\n"
+
+	tests := []struct {
+		strict, exclude bool
+		wantFiles       int
+		wantFailed      int
+		wantFlagged     int
+	}{
+		{false, false, 2, 1, 0},
+		{true, false, 3, 0, 2},
+		{false, true, 1, 2, 0},
+		{true, true, 1, 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("strict=%t,exclude=%t", tt.strict, tt.exclude), func(t *testing.T) {
+			opts := testParseOptions(t)
+			opts.strictTimestamps = tt.strict
+			opts.excludeBadTimestamps = tt.exclude
+
+			files, stats, err := readListing(context.Background(), "test", strings.NewReader(listing), opts, discardLogger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != tt.wantFiles || stats.Failed != tt.wantFailed || stats.Flagged != tt.wantFlagged {
+				t.Errorf("got %d files, %d failed, %d flagged; want %d, %d, %d",
+					len(files), stats.Failed, stats.Flagged, tt.wantFiles, tt.wantFailed, tt.wantFlagged)
+			}
+			for _, file := range files {
+				if file.TimestampFlagged && file.TimestampSource != timestampSourceS3 {
+					t.Errorf("flagged %s uses its %s time, want s3", file.Filename, file.TimestampSource)
+				}
+			}
+		})
+	}
+}
+
+func TestRunStrictTimestamps(t *testing.T) {
+	listing := "2023-01-02 03:04:05 10 a/20230101_120000.mp4\n" +
+		"2023-01-02 03:04:05 10 a/20250101_120000.mp4\n"
+
+	// Each flag is a bare bool, so the -sort after it must still be parsed
+	tests := map[string]int{"-strict-timestamps": 2, "-exclude-bad-timestamps": 1}
+	for flag, wantRm := range tests {
+		t.Run(flag, func(t *testing.T) {
+			dir, _, err := runListing(t, listing, flag, "-sort", "none")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Count(readFile(t, filepath.Join(dir, "rm.sh")), "aws s3 rm "); got != wantRm {
+				t.Errorf("rm.sh has %d deletes, want %d", got, wantRm)
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// summary totals the final set of files.
+type summary struct {
+	Count      int        `json:"count"`
+	TotalBytes int64      `json:"total_bytes"`
+	Oldest     *time.Time `json:"oldest,omitempty"`
+	Newest     *time.Time `json:"newest,omitempty"`
+}
+
+func summarize(files []FileStruct) summary {
+	s := summary{Count: len(files)}
+	for i, file := range files {
+		s.TotalBytes += file.FileSize
+		if s.Oldest == nil || file.FileTimestamp.Before(*s.Oldest) {
+			s.Oldest = &files[i].FileTimestamp
+		}
+		if s.Newest == nil || file.FileTimestamp.After(*s.Newest) {
+			s.Newest = &files[i].FileTimestamp
+		}
+	}
+	return s
+}
+
+func (s summary) String() string {
+	line := fmt.Sprintf("%d files, %s total", s.Count, humanize.Bytes(uint64(s.TotalBytes)))
+	if s.Oldest != nil {
+		line += fmt.Sprintf(", oldest %s, newest %s",
+			s.Oldest.Format("2006-01-02 15:04:05"), s.Newest.Format("2006-01-02 15:04:05"))
+	}
+	return line
+}
+
+// timestampCoverage counts how many files carry a timestamp in their name.
+type timestampCoverage struct {
+	Embedded int     `json:"embedded"`
+	Fallback int     `json:"fallback"`
+	Percent  float64 `json:"percent"`
+}
+
+func computeTimestampCoverage(files []FileStruct) timestampCoverage {
+	var coverage timestampCoverage
+	for _, file := range files {
+		if file.TimestampSource == timestampSourceFilename {
+			coverage.Embedded++
+		} else {
+			coverage.Fallback++
+		}
+	}
+	if len(files) > 0 {
+		coverage.Percent = 100 * float64(coverage.Embedded) / float64(len(files))
+	}
+	return coverage
+}
+
+func (c timestampCoverage) String() string {
+	return fmt.Sprintf("Timestamp coverage: %.1f%% (%d embedded in filename, %d fell back to S3 time)",
+		c.Percent, c.Embedded, c.Fallback)
+}
+
+// formatDrift counts which timestamp pattern each file's name matched. Every
+// known pattern is checked, not just the configured ones, so a new naming
+// scheme that parsing ignores still shows up.
+type formatDrift struct {
+	patterns   []timestampPattern
+	configured map[string]bool
+	counts     map[string]int
+	none       int
+}
+
+// computeFormatDrift classifies files by the configured patterns first, in
+// the order parsing tries them, and then by the remaining known ones.
+func computeFormatDrift(files []FileStruct, configured []timestampPattern) formatDrift {
+	drift := formatDrift{configured: make(map[string]bool), counts: make(map[string]int)}
+	for _, pattern := range configured {
+		drift.configured[pattern.name] = true
+	}
+	drift.patterns = append(drift.patterns, configured...)
+	for _, pattern := range timestampPatterns {
+		if !drift.configured[pattern.name] {
+			drift.patterns = append(drift.patterns, pattern)
+		}
+	}
+
+	for _, file := range files {
+		if pattern, _ := matchTimestampPattern(file.Filename, drift.patterns); pattern != nil {
+			drift.counts[pattern.name]++
+		} else {
+			drift.none++
+		}
+	}
+	return drift
+}
+
+// mixed reports whether more than one timestamp format is in use.
+func (d formatDrift) mixed() bool {
+	return len(d.counts) > 1
+}
+
+// unconfigured returns the patterns that matched files but are not
+// configured, so those files fell back to their S3 time.
+func (d formatDrift) unconfigured() []string {
+	var names []string
+	for _, pattern := range d.patterns {
+		if d.counts[pattern.name] > 0 && !d.configured[pattern.name] {
+			names = append(names, pattern.name)
+		}
+	}
+	return names
+}
+
+// String lists every known pattern, configured ones first, so a format that
+// has stopped appearing shows up as a zero.
+func (d formatDrift) String() string {
+	var out strings.Builder
+	out.WriteString("Timestamp formats:\n")
+	for _, pattern := range d.patterns {
+		status := "configured"
+		if !d.configured[pattern.name] {
+			status = "not configured"
+		}
+		fmt.Fprintf(&out, "  %-8s %-20s %-6d %s\n", pattern.name, pattern.layout, d.counts[pattern.name], status)
+	}
+	fmt.Fprintf(&out, "  %-8s %-20s %d\n", "none", "", d.none)
+	return out.String()
+}
+
+// listPageSize is the most keys one S3 ListObjectsV2 request returns.
+const listPageSize = 1000
+
+// listRequestsSaved estimates how many S3 LIST requests it would have taken
+// to fetch entries listing entries directly, at one request per full page.
+func listRequestsSaved(entries int) int {
+	return (entries + listPageSize - 1) / listPageSize
+}
+
+// formatErrorHistogram renders parse error counts, most frequent first.
+func formatErrorHistogram(counts map[parseErrorKind]int) string {
+	kinds := make([]parseErrorKind, 0, len(counts))
+	total := 0
+	for kind, count := range counts {
+		kinds = append(kinds, kind)
+		total += count
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if counts[kinds[i]] != counts[kinds[j]] {
+			return counts[kinds[i]] > counts[kinds[j]]
+		}
+		return kinds[i] < kinds[j]
+	})
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "Parse errors: %d\n", total)
+	for _, kind := range kinds {
+		fmt.Fprintf(&out, "  %-28s %d\n", kind, counts[kind])
+	}
+	return out.String()
+}
+
+// rootPrefix labels keys that have no top-level prefix.
+const rootPrefix = "(root)"
+
+// topLevelPrefix returns the first path segment of key including its
+// trailing slash, or rootPrefix for keys at the top of the bucket.
+func topLevelPrefix(key string) string {
+	if i := strings.IndexByte(key, '/'); i >= 0 {
+		return key[:i+1]
+	}
+	return rootPrefix
+}
+
+// prefixAge is the oldest file found under a top-level prefix.
+type prefixAge struct {
+	Prefix string
+	Oldest FileStruct
+}
+
+// oldestPerPrefix finds the oldest file under each top-level prefix, ordered
+// stalest first.
+func oldestPerPrefix(files []FileStruct) []prefixAge {
+	oldest := make(map[string]FileStruct)
+	for _, file := range files {
+		prefix := topLevelPrefix(file.Filename)
+		if current, ok := oldest[prefix]; !ok || file.FileTimestamp.Before(current.FileTimestamp) {
+			oldest[prefix] = file
+		}
+	}
+
+	ages := make([]prefixAge, 0, len(oldest))
+	for prefix, file := range oldest {
+		ages = append(ages, prefixAge{Prefix: prefix, Oldest: file})
+	}
+	sort.Slice(ages, func(i, j int) bool {
+		ti, tj := ages[i].Oldest.FileTimestamp, ages[j].Oldest.FileTimestamp
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return ages[i].Prefix < ages[j].Prefix
+	})
+	return ages
+}
+
+// formatPrefixAgeReport renders oldestPerPrefix as an aligned table.
+func formatPrefixAgeReport(ages []prefixAge, units ageUnits) string {
+	var out strings.Builder
+	tw := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PREFIX\tOLDEST AGE\tOLDEST KEY")
+	for _, age := range ages {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", age.Prefix, formatRelativeTime(age.Oldest.FileTimestamp, units), age.Oldest.Filename)
+	}
+	tw.Flush()
+	return out.String()
+}
+
+// groupFuncs are available to -group-by templates alongside the FileStruct
+// fields, e.g. {{prefix .Filename}} or {{.FileTimestamp.Format "2006-01"}}.
+var groupFuncs = template.FuncMap{
+	"prefix": topLevelPrefix,
+	"dir":    path.Dir,
+	"base":   path.Base,
+	"ext":    path.Ext,
+}
+
+// parseGroupTemplate parses a -group-by template and checks that it can be
+// executed, so mistakes are reported before any input is read.
+func parseGroupTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("group").Funcs(groupFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -group-by template: %w", err)
+	}
+	if err := tmpl.Execute(new(strings.Builder), FileStruct{}); err != nil {
+		return nil, fmt.Errorf("invalid -group-by template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// fileGroup totals the files whose -group-by template rendered to Key.
+type fileGroup struct {
+	Key        string
+	Count      int
+	TotalBytes int64
+}
+
+// groupFiles buckets files by the rendered value of tmpl, ordered by key.
+func groupFiles(files []FileStruct, tmpl *template.Template) ([]fileGroup, error) {
+	index := make(map[string]int)
+	var groups []fileGroup
+	for _, file := range files {
+		var key strings.Builder
+		if err := tmpl.Execute(&key, file); err != nil {
+			return nil, fmt.Errorf("failed to group '%s': %w", file.Filename, err)
+		}
+		i, ok := index[key.String()]
+		if !ok {
+			i = len(groups)
+			index[key.String()] = i
+			groups = append(groups, fileGroup{Key: key.String()})
+		}
+		groups[i].Count++
+		groups[i].TotalBytes += file.FileSize
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Key < groups[j].Key })
+	return groups, nil
+}
+
+// formatGroupReport renders groupFiles as an aligned table.
+func formatGroupReport(groups []fileGroup) string {
+	var out strings.Builder
+	tw := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "GROUP\tFILES\tSIZE")
+	for _, group := range groups {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", group.Key, group.Count, humanize.Bytes(uint64(group.TotalBytes)))
+	}
+	tw.Flush()
+	return out.String()
+}
+
+// heatmapBucket is one band of a heatmap axis. A value falls in the first
+// bucket whose limit it is below; limit 0 means unbounded.
+type heatmapBucket struct {
+	label string
+	limit float64
+}
+
+var (
+	heatmapAgeBuckets = []heatmapBucket{
+		{"<1d", 1}, {"<1w", 7}, {"<30d", 30}, {"<1y", 365}, {">=1y", 0},
+	}
+	heatmapSizeBuckets = []heatmapBucket{
+		{"<1KB", 1e3}, {"<1MB", 1e6}, {"<100MB", 100e6}, {"<1GB", 1e9}, {">=1GB", 0},
+	}
+)
+
+func heatmapIndex(buckets []heatmapBucket, value float64) int {
+	for i, bucket := range buckets {
+		if bucket.limit == 0 || value < bucket.limit {
+			return i
+		}
+	}
+	return len(buckets) - 1
+}
+
+// heatmap counts files by age in days at now and by size.
+func heatmap(files []FileStruct, now time.Time) [][]int {
+	counts := make([][]int, len(heatmapAgeBuckets))
+	for i := range counts {
+		counts[i] = make([]int, len(heatmapSizeBuckets))
+	}
+	for _, file := range files {
+		age := now.Sub(file.FileTimestamp).Hours() / 24
+		counts[heatmapIndex(heatmapAgeBuckets, age)][heatmapIndex(heatmapSizeBuckets, float64(file.FileSize))]++
+	}
+	return counts
+}
+
+// formatHeatmap renders heatmap as a grid with an age bucket per row and a
+// size bucket per column.
+func formatHeatmap(counts [][]int) string {
+	var out strings.Builder
+	tw := tabwriter.NewWriter(&out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "AGE \\ SIZE\t")
+	for _, bucket := range heatmapSizeBuckets {
+		fmt.Fprintf(tw, "%s\t", bucket.label)
+	}
+	fmt.Fprintln(tw)
+	for i, row := range counts {
+		fmt.Fprintf(tw, "%s\t", heatmapAgeBuckets[i].label)
+		for _, count := range row {
+			fmt.Fprintf(tw, "%d\t", count)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	return out.String()
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputeTimestampCoverage(t *testing.T) {
+	tests := []struct {
+		embedded []bool
+		want     timestampCoverage
+	}{
+		{nil, timestampCoverage{}},
+		{[]bool{true, false, false, true}, timestampCoverage{2, 2, 50}},
+		{[]bool{true, false, false}, timestampCoverage{1, 2, 100.0 / 3}},
+		{[]bool{false}, timestampCoverage{0, 1, 0}},
+	}
+	for _, tt := range tests {
+		var files []FileStruct
+		for _, embedded := range tt.embedded {
+			source := timestampSourceS3
+			if embedded {
+				source = timestampSourceFilename
+			}
+			files = append(files, FileStruct{TimestampSource: source})
+		}
+		if got := computeTimestampCoverage(files); got != tt.want {
+			t.Errorf("computeTimestampCoverage(%v) = %+v, want %+v", tt.embedded, got, tt.want)
+		}
+	}
+}
+
+func TestRunTimestampCoverage(t *testing.T) {
+	listing := "2023-01-02 03:04:05 1 a/20230101_120000.mp4\n" +
+		"2023-01-02 03:04:05 1 b/20230101_130000.mp4\n" +
+		"2023-01-02 03:04:05 1 c/20230101_140000.mp4\n" +
+		"2023-01-02 03:04:05 1 notes.txt\n"
+	dir, _, err := runListing(t, listing, "-timestamp-coverage", "-format", "json-document")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := readResultsDocument(t, filepath.Join(dir, "results.json"))
+	want := timestampCoverage{Embedded: 3, Fallback: 1, Percent: 75}
+	if doc.TimestampCoverage == nil || *doc.TimestampCoverage != want {
+		t.Errorf("timestamp_coverage = %+v, want %+v", doc.TimestampCoverage, want)
+	}
+	if doc.Summary.Count != 4 || doc.Summary.TotalBytes != 4 {
+		t.Errorf("summary = %+v, want 4 files and 4 bytes", doc.Summary)
+	}
+
+	// The default bare array has no room for it, so it goes in the summary
+	dir, _, err = runListing(t, listing, "-timestamp-coverage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readResultsSummary(t, filepath.Join(dir, summaryFilename))
+	if got.TimestampCoverage == nil || *got.TimestampCoverage != want {
+		t.Errorf("%s timestamp_coverage = %+v, want %+v", summaryFilename, got.TimestampCoverage, want)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	early := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	late := early.Add(48 * time.Hour)
+	files := []FileStruct{
+		{FileSize: 10, FileTimestamp: late},
+		{FileSize: 5, FileTimestamp: early},
+		{FileSize: 1, FileTimestamp: early.Add(time.Hour)},
+	}
+
+	s := summarize(files)
+	if s.Count != 3 || s.TotalBytes != 16 || !s.Oldest.Equal(early) || !s.Newest.Equal(late) {
+		t.Errorf("summarize = %+v", s)
+	}
+	if got, want := s.String(), "3 files, 16 B total, oldest 2023-01-01 00:00:00, newest 2023-01-03 00:00:00"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+
+	empty := summarize(nil)
+	if empty.Oldest != nil || empty.String() != "0 files, 0 B total" {
+		t.Errorf("summarize(nil) = %+v %q", empty, empty.String())
+	}
+}
+
+func TestFormatErrorHistogram(t *testing.T) {
+	got := formatErrorHistogram(map[parseErrorKind]int{errBadSize: 1, errShortLine: 3, errBadTimestamp: 1})
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	if lines[0] != "Parse errors: 5" {
+		t.Errorf("header = %q", lines[0])
+	}
+	var kinds []string
+	for _, line := range lines[1:] {
+		kinds = append(kinds, strings.Fields(line)[0])
+	}
+	// Most frequent first, then alphabetical
+	if want := []string{string(errShortLine), string(errBadSize), string(errBadTimestamp)}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("order = %q, want %q", kinds, want)
+	}
+}
+
+func TestRunParseErrors(t *testing.T) {
+	listing := "2023-01-02 03:04:05 1 good.txt\nshort\n2023-01-02 03:04:05 lots big.txt\n"
+	dir, stdout, err := runListing(t, listing, "-format", "json-document")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout, "Parse errors: 2") {
+		t.Errorf("stdout has no histogram:\n%s", stdout)
+	}
+
+	doc := readResultsDocument(t, filepath.Join(dir, "results.json"))
+	if want := map[parseErrorKind]int{errShortLine: 1, errBadSize: 1}; !reflect.DeepEqual(doc.Input.Errors, want) {
+		t.Errorf("results.json parse_errors = %v, want %v", doc.Input.Errors, want)
+	}
+
+	dir, _, err = runListing(t, listing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readResultsSummary(t, filepath.Join(dir, summaryFilename))
+	if !reflect.DeepEqual(got.Input, doc.Input) {
+		t.Errorf("%s input = %+v, want %+v", summaryFilename, got.Input, doc.Input)
+	}
+}
+
+func TestOldestPerPrefix(t *testing.T) {
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := []FileStruct{
+		{Filename: "a/new", FileTimestamp: base.Add(3 * time.Hour)},
+		{Filename: "a/old", FileTimestamp: base.Add(time.Hour)},
+		{Filename: "b/only", FileTimestamp: base.Add(2 * time.Hour)},
+		{Filename: "top", FileTimestamp: base},
+	}
+
+	var got []string
+	for _, age := range oldestPerPrefix(files) {
+		got = append(got, age.Prefix+" "+age.Oldest.Filename)
+	}
+	if want := []string{rootPrefix + " top", "a/ a/old", "b/ b/only"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("oldestPerPrefix = %q, want %q", got, want)
+	}
+}
+
+func TestComputeFormatDrift(t *testing.T) {
+	files := []FileStruct{
+		{Filename: "a/20230101_120000.mp4"},
+		{Filename: "a/20230102_120000.mp4"},
+		{Filename: "b/20230101T120000.mp4"},
+		{Filename: "c/2023-01-01_12-00-00.mp4"},
+		{Filename: "notes.txt"},
+	}
+
+	tests := []struct {
+		configured       []string
+		wantUnconfigured []string
+	}{
+		{[]string{"compact"}, []string{"basic", "dashed"}},
+		{[]string{"compact", "basic"}, []string{"dashed"}},
+		{[]string{"compact", "basic", "dashed"}, nil},
+	}
+	for _, tt := range tests {
+		configured, err := lookupTimestampPatterns(tt.configured)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drift := computeFormatDrift(files, configured)
+
+		want := map[string]int{"compact": 2, "basic": 1, "dashed": 1}
+		if !reflect.DeepEqual(drift.counts, want) || drift.none != 1 || !drift.mixed() {
+			t.Errorf("with %q: counts = %v, none = %d; want %v, 1", tt.configured, drift.counts, drift.none, want)
+		}
+		if got := drift.unconfigured(); !reflect.DeepEqual(got, tt.wantUnconfigured) {
+			t.Errorf("with %q: unconfigured = %q, want %q", tt.configured, got, tt.wantUnconfigured)
+		}
+	}
+
+	configured, _ := lookupTimestampPatterns([]string{"compact"})
+	report := computeFormatDrift(files[:2], configured).String()
+	if !strings.Contains(report, "compact") || !strings.Contains(report, "not configured") {
+		t.Errorf("report does not list unused patterns:\n%s", report)
+	}
+	if computeFormatDrift(files[:2], configured).mixed() {
+		t.Error("a single format was reported as mixed")
+	}
+}
+
+func TestListRequestsSaved(t *testing.T) {
+	tests := map[int]int{0: 0, 1: 1, 999: 1, 1000: 1, 1001: 2, 250000: 250}
+	for entries, want := range tests {
+		if got := listRequestsSaved(entries); got != want {
+			t.Errorf("listRequestsSaved(%d) = %d, want %d", entries, got, want)
+		}
+	}
+}
+
+func TestGroupFiles(t *testing.T) {
+	files := []FileStruct{
+		{Filename: "b/x.mp4", FileSize: 1, FileTimestamp: time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{Filename: "a/y.mp4", FileSize: 2, FileTimestamp: time.Date(2023, 1, 9, 0, 0, 0, 0, time.UTC)},
+		{Filename: "a/z.txt", FileSize: 4, FileTimestamp: time.Date(2023, 1, 5, 0, 0, 0, 0, time.UTC)},
+	}
+
+	tests := []struct {
+		template string
+		want     []fileGroup
+	}{
+		{"{{prefix .Filename}}", []fileGroup{{"a/", 2, 6}, {"b/", 1, 1}}},
+		{"{{ext .Filename}}", []fileGroup{{".mp4", 2, 3}, {".txt", 1, 4}}},
+		{`{{.FileTimestamp.Format "2006-01"}}`, []fileGroup{{"2023-01", 2, 6}, {"2023-02", 1, 1}}},
+		{"{{dir .Filename}}/{{base .Filename}}", []fileGroup{{"a/y.mp4", 1, 2}, {"a/z.txt", 1, 4}, {"b/x.mp4", 1, 1}}},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseGroupTemplate(tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := groupFiles(files, tmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("groupFiles(%s) = %+v, want %+v", tt.template, got, tt.want)
+		}
+	}
+
+	for _, text := range []string{"{{.Nope}}", "{{prefix .Filename", "{{upper .Filename}}"} {
+		if _, err := parseGroupTemplate(text); err == nil || !strings.Contains(err.Error(), "invalid -group-by template") {
+			t.Errorf("parseGroupTemplate(%q) error = %v", text, err)
+		}
+	}
+}
+
+func TestHeatmap(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	files := []FileStruct{
+		{FileTimestamp: now.Add(-time.Hour), FileSize: 10},
+		{FileTimestamp: now.Add(-time.Hour), FileSize: 999},
+		{FileTimestamp: now.Add(-3 * day), FileSize: 1000},
+		{FileTimestamp: now.Add(-7 * day), FileSize: 50e6},
+		{FileTimestamp: now.Add(-400 * day), FileSize: 2e9},
+		{FileTimestamp: now.Add(day), FileSize: 1},
+	}
+
+	counts := heatmap(files, now)
+	if len(counts) != len(heatmapAgeBuckets) || len(counts[0]) != len(heatmapSizeBuckets) {
+		t.Fatalf("grid is %dx%d, want %dx%d", len(counts), len(counts[0]), len(heatmapAgeBuckets), len(heatmapSizeBuckets))
+	}
+
+	want := [][]int{
+		{3, 0, 0, 0, 0},
+		{0, 1, 0, 0, 0},
+		{0, 0, 1, 0, 0},
+		{0, 0, 0, 0, 0},
+		{0, 0, 0, 0, 1},
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("heatmap = %v, want %v", counts, want)
+	}
+
+	lines := strings.Split(strings.TrimSpace(formatHeatmap(counts)), "\n")
+	if len(lines) != 1+len(heatmapAgeBuckets) || !strings.Contains(lines[0], ">=1GB") {
+		t.Errorf("formatHeatmap:\n%s", strings.Join(lines, "\n"))
+	}
+}
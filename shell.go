@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// bucket is the S3 bucket the generated commands operate on.
+const bucket = "streamboxdineorb"
+
+// shell describes how generated scripts are written for a target shell.
+// Adding a shell means adding an entry to shells.
+type shell struct {
+	extension  string
+	lineEnding string
+
+	// echo and sleep render commands that print message and pause.
+	echo  func(message string) string
+	sleep func(seconds int) string
+
+	// quote renders s as a single literal argument, and unquote decodes the
+	// leading word of a line back, as -exclude-log does for old rm scripts.
+	quote   func(s string) string
+	unquote func(word string) string
+
+	// mkdir renders a command creating dir and any missing parents.
+	mkdir func(dir string) string
+
+	// rmTemplate and syncTemplate are the default command templates; see
+	// commandData for the fields they can use.
+	rmTemplate   string
+	syncTemplate string
+}
+
+var shells = map[string]shell{
+	"bash": {
+		extension:  ".sh",
+		lineEnding: "\n",
+		echo:       func(message string) string { return "echo " + bashQuote(message) },
+		sleep:      func(seconds int) string { return fmt.Sprintf("sleep %d", seconds) },
+		quote:      bashQuote,
+		unquote:    unquoteShellWord,
+		mkdir:      func(dir string) string { return "mkdir -p " + bashQuote(dir) },
+		rmTemplate: `aws s3 rm {{shellquote (print "s3://" .Bucket "/" .Filename)}}`,
+		syncTemplate: `aws s3 sync {{shellquote (print "s3://" .Bucket)}} {{shellword .SyncDest}}` +
+			` --exclude='*' --include={{shellquote .Filename}}`,
+	},
+	"powershell": {
+		extension:  ".ps1",
+		lineEnding: "\r\n",
+		echo:       func(message string) string { return "Write-Host " + powershellQuote(message) },
+		sleep:      func(seconds int) string { return fmt.Sprintf("Start-Sleep -Seconds %d", seconds) },
+		quote:      powershellQuote,
+		unquote:    unquotePowerShellWord,
+		mkdir: func(dir string) string {
+			return "New-Item -ItemType Directory -Force -Path " + powershellQuote(dir) + " | Out-Null"
+		},
+		rmTemplate: `aws s3 rm {{shellquote (print "s3://" .Bucket "/" .Filename)}}`,
+		syncTemplate: `aws s3 sync {{shellquote (print "s3://" .Bucket)}} {{shellword .SyncDest}}` +
+			` '--exclude=*' {{shellquote (print "--include=" .Filename)}}`,
+	},
+}
+
+func lookupShell(name string) (shell, error) {
+	sh, ok := shells[name]
+	if !ok {
+		return shell{}, fmt.Errorf("invalid shell '%s': use 'bash' or 'powershell'", name)
+	}
+	return sh, nil
+}
+
+// bashQuote single-quotes s, splicing in '"'"' for embedded single quotes.
+func bashQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// powershellQuote single-quotes s, doubling embedded single quotes.
+func powershellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// plainWord matches arguments that need no quoting in any supported shell.
+var plainWord = regexp.MustCompile(`^[A-Za-z0-9_./:=@%+-]+$`)
+
+// quoteIfNeeded leaves plain paths such as /tmp/video bare and quotes the
+// rest.
+func (sh shell) quoteIfNeeded(s string) string {
+	if plainWord.MatchString(s) {
+		return s
+	}
+	return sh.quote(s)
+}
+
+// rmBanner announces how much the rm script is about to delete and pauses
+// for sleepSeconds so the operator can abort.
+func (sh shell) rmBanner(files []FileStruct, sleepSeconds int) string {
+	s := summarize(files)
+	banner := sh.echo(fmt.Sprintf("About to delete %d objects totaling %s", s.Count, humanize.Bytes(uint64(s.TotalBytes)))) + "\n"
+	if sleepSeconds > 0 {
+		banner += sh.sleep(sleepSeconds) + "\n"
+	}
+	return banner
+}
+
+// script converts newline-terminated content to the shell's line endings.
+func (sh shell) script(content string) string {
+	if sh.lineEnding == "\n" {
+		return content
+	}
+	return strings.ReplaceAll(content, "\n", sh.lineEnding)
+}